@@ -0,0 +1,143 @@
+package dirtree
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+const (
+	statusAdded   = "added"
+	statusRemoved = "removed"
+	statusChanged = "changed"
+)
+
+// diffMarkers and diffColors decorate text lines by node status
+var (
+	diffMarkers = map[string]string{
+		"":            "  ",
+		statusAdded:   "+ ",
+		statusRemoved: "- ",
+		statusChanged: "~ ",
+	}
+	diffColors = map[string]string{
+		statusAdded:   "\x1b[32m",
+		statusRemoved: "\x1b[31m",
+		statusChanged: "\x1b[33m",
+	}
+)
+
+const colorReset = "\x1b[0m"
+
+// differ merges the trees walked from two file systems
+type differ struct {
+	oldFS, newFS fs.FS
+	hash         bool
+}
+
+// merge combines two levels into one, entries are matched by name;
+// an entry that changed between file and directory is listed as removed and added
+func (d *differ) merge(oldNodes, newNodes []*Node) []*Node {
+	byName := make(map[string]*Node, len(oldNodes))
+	for _, n := range oldNodes {
+		byName[n.Name] = n
+	}
+
+	var merged []*Node
+	for _, n := range newNodes {
+		old, ok := byName[n.Name]
+		if !ok {
+			merged = append(merged, markAll(n, statusAdded))
+			continue
+		}
+		delete(byName, n.Name)
+		switch {
+		case old.Type != n.Type:
+			merged = append(merged, markAll(old, statusRemoved), markAll(n, statusAdded))
+		case n.IsDir():
+			n.Children = d.merge(old.Children, n.Children)
+			merged = append(merged, n)
+		default:
+			if d.changed(old, n) {
+				n.Status = statusChanged
+				n.OldSize = old.Size
+			}
+			merged = append(merged, n)
+		}
+	}
+	for _, n := range oldNodes {
+		if _, ok := byName[n.Name]; ok {
+			merged = append(merged, markAll(n, statusRemoved))
+		}
+	}
+	return merged
+}
+
+func (d *differ) changed(old, n *Node) bool {
+	if old.Size != n.Size || old.Target != n.Target {
+		return true
+	}
+	if !d.hash || n.Type != typeFile {
+		return false
+	}
+	oldSum, errOld := fileHash(d.oldFS, old.fsPath)
+	newSum, errNew := fileHash(d.newFS, n.fsPath)
+	return errOld != nil || errNew != nil || oldSum != newSum
+}
+
+// markAll sets status on n and its whole subtree
+func markAll(n *Node, status string) *Node {
+	n.Status = status
+	for _, child := range n.Children {
+		markAll(child, status)
+	}
+	return n
+}
+
+func fileHash(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return string(h.Sum(nil)), nil
+}
+
+// Diff prints a merged tree of the directories oldPath and newPath
+// with every added, removed and changed entry marked
+func Diff(out io.Writer, oldPath, newPath string, opts Options) error {
+	d := &differ{oldFS: newOSFS(oldPath), newFS: newOSFS(newPath), hash: opts.Hash}
+	// errors of both trees are told apart by their root
+	oldOpts := opts
+	oldOpts.errRoot = oldPath
+	oldRoot, oldErr := buildTree(d.oldFS, ".", filepath.Base(oldPath), oldOpts)
+	if oldRoot == nil {
+		return oldErr
+	}
+	opts.errRoot = newPath
+	root, err := buildTree(d.newFS, ".", filepath.Base(newPath), opts)
+	if root == nil {
+		return err
+	}
+	root.Children = d.merge(oldRoot.Children, root.Children)
+	sortNodes(root.Children, opts)
+	opts.diff = true
+	if renderErr := render(out, root, opts); renderErr != nil {
+		return renderErr
+	}
+	var errs WalkErrors
+	for _, walkErr := range []error{oldErr, err} {
+		if walkErr != nil {
+			errs = append(errs, walkErr.(WalkErrors)...)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package dirtree
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// dirKey identifies a directory, or a file, by its resolved absolute path
+// where device and inode numbers are not available;
+// only entries of the OS file system can be resolved and hard links are not found
+func dirKey(fsys fs.FS, name string, info fs.FileInfo) string {
+	ofs, ok := fsys.(osFS)
+	if !ok {
+		return ""
+	}
+	full, err := ofs.path(name)
+	if err != nil {
+		return ""
+	}
+	resolved, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return ""
+	}
+	abs, err := filepath.Abs(resolved)
+	if err != nil {
+		return ""
+	}
+	return abs
+}
@@ -0,0 +1,23 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dirtree
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// dirKey identifies a directory, or a file, by its device and inode,
+// so loops through symlinks are found whatever path leads to them
+func dirKey(fsys fs.FS, name string, info fs.FileInfo) string {
+	if info == nil {
+		return ""
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatUint(uint64(st.Dev), 10) + ":" + strconv.FormatUint(uint64(st.Ino), 10)
+}
@@ -0,0 +1,191 @@
+// Package dirtree walks a directory of any fs.FS into a tree of nodes
+// and renders it as box-drawing text, JSON, XML or HTML.
+package dirtree
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+)
+
+// Options controls which entries are walked and how the tree is rendered
+type Options struct {
+	// Files lists files, otherwise only directories are shown
+	Files bool
+	// Depth is the number of levels to descend, 0 for no limit
+	Depth int
+	// Exclude and Include hold gitignore-style patterns; Include only applies to files
+	Exclude []string
+	Include []string
+	// Gitignore honors .gitignore files found along the walk and hides .git
+	Gitignore bool
+	// Prune hides directories without files
+	Prune bool
+	// Du annotates directories with their subtree totals, Human prints sizes in KiB, MiB...
+	Du    bool
+	Human bool
+	// Summary prints the directory, file and size totals after the text tree
+	Summary bool
+	// Sort is one of name (the default), size, mtime, ext or version
+	Sort      string
+	Reverse   bool
+	DirsFirst bool
+	// FollowLinks descends into symlinked directories, loops are not followed
+	FollowLinks bool
+	// Hash compares file contents in Diff, Color marks its output with ANSI colors
+	Hash  bool
+	Color bool
+	// Dupes reports groups of files with identical contents
+	Dupes bool
+	// Mime sniffs the media type of every file from its contents,
+	// Types prints the number of files and bytes per media type after the text tree
+	Mime  bool
+	Types bool
+	// Loc counts lines, blank lines and comment lines of text files, rolls them up
+	// onto directories and prints the totals per language after the text tree
+	Loc bool
+	// Perms, Owner, Group, Date and Inodes add metadata columns before each name;
+	// TimeFormat is a time.Format layout for dates, DefaultTimeFormat if empty
+	Perms      bool
+	Owner      bool
+	Group      bool
+	Date       bool
+	Inodes     bool
+	TimeFormat string
+	// Workers is the number of directories read concurrently, 0 or 1 walks serially
+	Workers int
+
+	// Formatter renders the tree, Text if nil
+	Formatter Formatter
+	// Visitor is called for every node before the tree is rendered
+	Visitor Visitor
+
+	// diff is set internally when rendering a merged tree
+	diff bool
+	// errRoot is the path the caller gave for the walked file system,
+	// walk errors name entries below it
+	errRoot string
+}
+
+// errPath is the name of an entry of the walked file system in errors
+func (opts Options) errPath(name string) string {
+	if opts.errRoot == "" {
+		return name
+	}
+	return filepath.Join(opts.errRoot, filepath.FromSlash(name))
+}
+
+// needTotals reports whether directory totals must cover the whole subtree
+func (opts Options) needTotals() bool {
+	_, data := opts.Formatter.(dataFormatter)
+	return opts.Du || opts.Sort == "size" || opts.Loc || data
+}
+
+// sniff reports whether file media types must be detected
+func (opts Options) sniff() bool {
+	return opts.Mime || opts.Types || opts.Loc
+}
+
+// readContents reports whether file contents are read, not only their metadata
+func (opts Options) readContents() bool {
+	return opts.Gitignore || opts.Dupes || opts.Hash || opts.sniff()
+}
+
+// Visitor is called for every node of a built tree, parents before children
+// in display order, with the root at depth 0. Returning fs.SkipDir skips
+// the children of a directory, any other error stops the walk.
+type Visitor interface {
+	Visit(n *Node, depth int) error
+}
+
+// VisitorFunc adapts a function to the Visitor interface
+type VisitorFunc func(n *Node, depth int) error
+
+func (f VisitorFunc) Visit(n *Node, depth int) error {
+	return f(n, depth)
+}
+
+// Walk calls v for root and every node below it
+func Walk(root *Node, v Visitor) error {
+	err := visit(root, 0, v)
+	if errors.Is(err, fs.SkipDir) {
+		return nil
+	}
+	return err
+}
+
+func visit(n *Node, depth int, v Visitor) error {
+	if err := v.Visit(n, depth); err != nil {
+		return err
+	}
+	for _, child := range n.Children {
+		err := visit(child, depth+1, v)
+		if errors.Is(err, fs.SkipDir) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tree walks the directory dir of fsys; entries that could not be read
+// are kept in the tree and also returned as WalkErrors
+func Tree(fsys fs.FS, dir string, opts Options) (*Node, error) {
+	return buildTree(fsys, dir, path.Base(dir), opts)
+}
+
+// Write prints the directory dir of any file system, such as an embed.FS or an opened archive
+func Write(out io.Writer, fsys fs.FS, dir string, opts Options) error {
+	return write(out, fsys, dir, path.Base(dir), opts)
+}
+
+// WriteDir prints the directory at an OS path
+func WriteDir(out io.Writer, dir string, opts Options) error {
+	opts.errRoot = dir
+	return write(out, newOSFS(dir), ".", filepath.Base(dir), opts)
+}
+
+// WriteArchive prints the directory dir inside a .zip, .tar or .tar.gz file;
+// the bodies of tar archives are read only if opts needs file contents
+func WriteArchive(out io.Writer, archive, dir string, opts Options) error {
+	fsys, closer, err := openArchive(archive, opts.readContents())
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	opts.errRoot = archive
+	name := filepath.Base(archive)
+	if dir != "." {
+		name = path.Base(dir)
+	}
+	return write(out, fsys, dir, name, opts)
+}
+
+func write(out io.Writer, fsys fs.FS, dir, name string, opts Options) error {
+	root, err := buildTree(fsys, dir, name, opts)
+	if root == nil {
+		return err
+	}
+	if renderErr := render(out, root, opts); renderErr != nil {
+		return renderErr
+	}
+	return err
+}
+
+// render runs the visitor and the formatter over a built tree
+func render(out io.Writer, root *Node, opts Options) error {
+	if opts.Visitor != nil {
+		if err := Walk(root, opts.Visitor); err != nil {
+			return err
+		}
+	}
+	formatter := opts.Formatter
+	if formatter == nil {
+		formatter = Text
+	}
+	return formatter.Format(out, root, opts)
+}
@@ -0,0 +1,1004 @@
+package dirtree
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestTreeJSON(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := WriteDir(out, "../testdata/project", Options{Files: true, Formatter: JSON})
+	if err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	var root Node
+	if err := json.Unmarshal(out.Bytes(), &root); err != nil {
+		t.Fatalf("cant decode json output: %v", err)
+	}
+	if root.Name != "project" || root.Type != typeDir || len(root.Children) != 2 {
+		t.Fatalf("unexpected root: %+v", root)
+	}
+	gopher := root.Children[1]
+	if gopher.Name != "gopher.png" || gopher.Type != typeFile || gopher.Size != 70372 {
+		t.Errorf("unexpected file node: %+v", gopher)
+	}
+}
+
+func TestTreeJSONTotals(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"a.txt":       "alpha",
+		"sub/b.txt":   "beta",
+		"sub/deep/c":  "gamma",
+		"sub/deep/d/": "",
+	})
+	if err := os.Symlink("a.txt", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	// totals cover the whole tree even without files and below the depth limit
+	if err := WriteDir(out, root, Options{Depth: 1, Formatter: JSON}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	var n Node
+	if err := json.Unmarshal(out.Bytes(), &n); err != nil {
+		t.Fatalf("cant decode json output: %v", err)
+	}
+	if n.Size != 14 || n.Files != 3 || n.Links != 1 || n.Dirs != 3 || len(n.Children) != 1 {
+		t.Errorf("unexpected root totals: %+v", n)
+	}
+	if sub := n.Children[0]; sub.Size != 9 || sub.Files != 2 || sub.Dirs != 2 || len(sub.Children) != 0 {
+		t.Errorf("unexpected directory totals: %+v", sub)
+	}
+}
+
+// makeTree creates files under a temporary directory; names ending in "/" are directories
+func makeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(full, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"*.png", "a/b/gopher.png", false, true},
+		{"*.png", "a/b/gopher.txt", false, false},
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"build/", "src/build", false, false},
+		{"build/", "src/build", true, true},
+		{"docs/*.md", "docs/a.md", false, true},
+		{"docs/*.md", "docs/sub/a.md", false, false},
+		{"**/logs", "a/b/logs", true, true},
+		{"a/**/z", "a/z", false, true},
+		{"a/**/z", "a/b/c/z", false, true},
+		{"a/**", "a/b/c", false, true},
+		{"file[0-9].txt", "file1.txt", false, true},
+		{"file[!0-9].txt", "file1.txt", false, false},
+		{`\#notes`, "#notes", false, true},
+	}
+	for _, c := range cases {
+		l := newIgnoreList("", []string{c.pattern})
+		if got := isIgnored([]*ignoreList{l}, c.path, c.isDir); got != c.ignored {
+			t.Errorf("pattern %q on %q (dir=%v): got %v, expected %v", c.pattern, c.path, c.isDir, got, c.ignored)
+		}
+	}
+}
+
+const testGitignoreResult = `├───.gitignore (24b)
+├───keep.log (empty)
+└───src
+	├───.gitignore (7b)
+	├───main.go (empty)
+	└───vendor.log (empty)
+`
+
+func TestTreeGitignore(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		".gitignore":            "*.log\n!keep.log\n/build/\n",
+		".git/HEAD":             "",
+		"build/out.bin":         "",
+		"keep.log":              "",
+		"debug.log":             "",
+		"node_modules/x/y.js":   "",
+		"src/.gitignore":        "!*.log\n",
+		"src/main.go":           "",
+		"src/vendor.log":        "",
+		"src/build/skipped.txt": "",
+	})
+	out := new(bytes.Buffer)
+	opts := Options{Files: true, Gitignore: true, Exclude: []string{"node_modules/", "src/build"}}
+	if err := WriteDir(out, root, opts); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testGitignoreResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testGitignoreResult)
+	}
+}
+
+const testDepthResult = `├───project
+│	├───file.txt (19b)
+│	└───gopher.png (70372b)
+├───static
+│	├───a_lorem
+│	├───css
+│	├───empty.txt (empty)
+│	├───html
+│	├───js
+│	└───z_lorem
+├───zline
+│	├───empty.txt (empty)
+│	└───lorem
+└───zzfile.txt (empty)
+`
+
+func TestTreeDepth(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := WriteDir(out, "../testdata", Options{Files: true, Depth: 2})
+	if err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testDepthResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDepthResult)
+	}
+}
+
+const testPruneResult = `├───static
+│	├───a_lorem
+│	│	└───dolor.txt (empty)
+│	└───z_lorem
+│		└───dolor.txt (empty)
+└───zline
+	└───lorem
+		└───dolor.txt (empty)
+`
+
+func TestTreePrune(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := WriteDir(out, "../testdata", Options{Files: true, Include: []string{"dolor.txt"}, Prune: true})
+	if err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testPruneResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testPruneResult)
+	}
+}
+
+const testDuResult = `├───project (68.7KiB, 2 files)
+├───static (137.5KiB, 8 files)
+│	├───a_lorem (68.7KiB, 2 files)
+│	├───css (28b, 1 file)
+│	├───html (57b, 1 file)
+│	├───js (10b, 1 file)
+│	└───z_lorem (68.7KiB, 2 files)
+└───zline (68.7KiB, 3 files)
+	└───lorem (68.7KiB, 2 files)
+
+9 directories, 14 files, total size 275.0KiB
+`
+
+func TestTreeDu(t *testing.T) {
+	out := new(bytes.Buffer)
+	opts := Options{Depth: 2, Du: true, Human: true, Summary: true, Exclude: []string{"ipsum/"}}
+	if err := WriteDir(out, "../testdata", opts); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testDuResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDuResult)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	names := []string{"file10", "file2", "file1.10", "file1.9", "a", "file02b"}
+	nodes := nodesNamed(names)
+	sortNodes(nodes, Options{Sort: "version"})
+	var got []string
+	for _, n := range nodes {
+		got = append(got, n.Name)
+	}
+	expected := "a file1.9 file1.10 file2 file02b file10"
+	if result := strings.Join(got, " "); result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func nodesNamed(names []string) []*Node {
+	var nodes []*Node
+	for _, name := range names {
+		nodes = append(nodes, newNode(name, typeFile))
+	}
+	return nodes
+}
+
+const testSortResult = `├───static
+│	├───a_lorem
+│	├───z_lorem
+│	├───html
+│	├───css
+│	├───js
+│	└───empty.txt (empty)
+├───zline
+│	├───lorem
+│	└───empty.txt (empty)
+├───project
+│	├───gopher.png (70372b)
+│	└───file.txt (19b)
+└───zzfile.txt (empty)
+`
+
+func TestTreeSort(t *testing.T) {
+	out := new(bytes.Buffer)
+	opts := Options{Files: true, Depth: 2, Sort: "size", DirsFirst: true}
+	if err := WriteDir(out, "../testdata", opts); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testSortResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testSortResult)
+	}
+}
+
+const testLinksResult = `├───a
+│	├───b
+│	│	├───file -> ../f.txt
+│	│	└───up -> .. [recursive, not followed]
+│	├───dead -> nowhere [dangling]
+│	└───f.txt (3b)
+└───alink -> a
+	├───b
+	│	├───file -> ../f.txt
+	│	└───up -> .. [recursive, not followed]
+	├───dead -> nowhere [dangling]
+	└───f.txt (3b)
+`
+
+func TestTreeLinks(t *testing.T) {
+	root := makeTree(t, map[string]string{"a/b/": "", "a/f.txt": "hi\n"})
+	links := map[string]string{"a/b/up": "..", "a/b/file": "../f.txt", "a/dead": "nowhere", "alink": "a"}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("cant create symlinks: %v", err)
+		}
+	}
+	out := new(bytes.Buffer)
+	if err := WriteDir(out, root, Options{Files: true, FollowLinks: true}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testLinksResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testLinksResult)
+	}
+}
+
+const testTarResult = `├───bin
+│	├───app (5b)
+│	└───current -> app
+└───etc
+	└───app.conf (empty)
+`
+
+func TestTreeTarGz(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "release.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	headers := []*tar.Header{
+		{Name: "./bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "./bin/app", Typeflag: tar.TypeReg, Mode: 0755, Size: 5},
+		{Name: "./bin/current", Typeflag: tar.TypeSymlink, Linkname: "app", Mode: 0777},
+		{Name: "./etc/app.conf", Typeflag: tar.TypeReg, Mode: 0644},
+	}
+	for _, hdr := range headers {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("hello"))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	out := new(bytes.Buffer)
+	if err := WriteArchive(out, archive, ".", Options{Files: true}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testTarResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testTarResult)
+	}
+}
+
+const testZipResult = `├───a
+│	└───lc -> ../c
+└───c
+	└───inc.txt (3b)
+`
+
+func TestTreeZipLinks(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "tree.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	link := &zip.FileHeader{Name: "a/lc"}
+	link.SetMode(fs.ModeSymlink | 0777)
+	for hdr, body := range map[*zip.FileHeader]string{link: "../c", {Name: "c/inc.txt"}: "inc"} {
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, body)
+	}
+	zw.Close()
+	f.Close()
+
+	out := new(bytes.Buffer)
+	if err := WriteArchive(out, archive, ".", Options{Files: true}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testZipResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testZipResult)
+	}
+}
+
+func TestTarFS(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "bin/app", Typeflag: tar.TypeReg, Mode: 0755, Size: 5})
+	tw.Write([]byte("hello"))
+	tw.WriteHeader(&tar.Header{Name: "bin/current", Typeflag: tar.TypeSymlink, Linkname: "app", Mode: 0777})
+	tw.Close()
+
+	tfs, err := readTar(bytes.NewReader(buf.Bytes()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(tfs, "bin/app", "bin/current"); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+
+	// without contents sizes still come from the headers
+	tfs, err = readTar(bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat(tfs, "bin/app")
+	if err != nil || info.Size() != 5 {
+		t.Errorf("unexpected stat: %v, %v", info, err)
+	}
+	if _, err := fs.ReadFile(tfs, "bin/app"); !errors.Is(err, errNoContents) {
+		t.Errorf("expected %v, got %v", errNoContents, err)
+	}
+}
+
+const testDiffResult = `  ├───css
+~ │	└───body.css (28b -> 30b)
+  ├───html
+~ │	└───index.html (57b)
+- ├───js
+- │	└───site.js (10b)
++ └───new.txt (2b)
+`
+
+func TestTreeDiff(t *testing.T) {
+	oldRoot := makeTree(t, map[string]string{
+		"css/body.css":    strings.Repeat("c", 28),
+		"html/index.html": strings.Repeat("h", 57),
+		"js/site.js":      strings.Repeat("j", 10),
+	})
+	newRoot := makeTree(t, map[string]string{
+		"css/body.css":    strings.Repeat("c", 30),
+		"html/index.html": strings.Repeat("H", 57),
+		"new.txt":         "y\n",
+	})
+	out := new(bytes.Buffer)
+	if err := Diff(out, oldRoot, newRoot, Options{Files: true, Hash: true}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testDiffResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDiffResult)
+	}
+}
+
+// failingFS reports permission denied when reading the listed directories
+type failingFS struct {
+	fstest.MapFS
+	denied map[string]bool
+}
+
+func (f failingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if f.denied[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return f.MapFS.ReadDir(name)
+}
+
+const testErrorsResult = `├───private [error opening dir: permission denied]
+└───public
+	└───index.html (empty)
+`
+
+func TestTreeErrors(t *testing.T) {
+	fsys := failingFS{
+		MapFS: fstest.MapFS{
+			"root/private/secret.txt": {},
+			"root/public/index.html":  {},
+		},
+		denied: map[string]bool{"root/private": true},
+	}
+	out := new(bytes.Buffer)
+	err := Write(out, fsys, "root", Options{Files: true, Prune: true})
+	if result := out.String(); result != testErrorsResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testErrorsResult)
+	}
+	errs, ok := err.(WalkErrors)
+	if !ok || len(errs) != 1 || !errors.Is(errs[0], fs.ErrPermission) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg := errs[0].Error(); msg != "root/private: error opening dir: permission denied" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWalkErrorPaths(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	err := WriteDir(new(bytes.Buffer), missing, Options{})
+	if expected := missing + ": error opening dir: "; err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("got %v, expected it to start with %q", err, expected)
+	}
+
+	// errors of the old and the new tree name their own root
+	present := makeTree(t, map[string]string{"a.txt": "alpha"})
+	err = Diff(new(bytes.Buffer), missing, present, Options{})
+	errs, ok := err.(WalkErrors)
+	if !ok || len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), missing+": ") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+const testDupesResult = `├───empty.txt (empty)
+└───lorem
+	├───dolor.txt (empty)
+	├───gopher.png (70372b) [dupe #1]
+	└───ipsum
+		└───gopher.png (70372b) [dupe #1]
+
+dupe #1: 2 copies of 70372b, wasted 70372b
+	lorem/gopher.png
+	lorem/ipsum/gopher.png
+1 duplicate group, wasted 70372b
+`
+
+func TestTreeDupes(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := WriteDir(out, "../testdata/zline", Options{Files: true, Dupes: true}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testDupesResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDupesResult)
+	}
+}
+
+func TestTreeDupesSameFile(t *testing.T) {
+	root := makeTree(t, map[string]string{"c/inc.txt": "incl", "d/copy.txt": "incl"})
+	if err := os.Symlink("../c", filepath.Join(root, "d", "lc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "c", "inc.txt"), filepath.Join(root, "c", "hard.txt")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+	tree, err := Tree(DirFS(root), ".", Options{Files: true, Dupes: true, FollowLinks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// c/hard.txt and d/lc/inc.txt are c/inc.txt again, only d/copy.txt is a copy
+	if len(tree.dupes) != 1 || len(tree.dupes[0].files) != 2 || tree.dupes[0].wasted() != 4 {
+		t.Errorf("unexpected dupes: %v", dupesText(tree.dupes, Options{}))
+	}
+}
+
+func TestVisitor(t *testing.T) {
+	var visited []string
+	visitor := VisitorFunc(func(n *Node, depth int) error {
+		visited = append(visited, strings.Repeat(" ", depth)+n.Name)
+		if n.Name == "static" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	out := new(bytes.Buffer)
+	if err := WriteDir(out, "../testdata", Options{Depth: 1, Visitor: visitor}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	expected := "testdata| project| static| zline"
+	if result := strings.Join(visited, "|"); result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestTreeParallel(t *testing.T) {
+	serial, parallel := new(bytes.Buffer), new(bytes.Buffer)
+	opts := Options{Files: true, Du: true, Summary: true}
+	if err := WriteDir(serial, "../testdata", opts); err != nil {
+		t.Fatalf("serial walk failed: %v", err)
+	}
+	opts.Workers = 8
+	for i := 0; i < 20; i++ {
+		parallel.Reset()
+		if err := WriteDir(parallel, "../testdata", opts); err != nil {
+			t.Fatalf("parallel walk failed: %v", err)
+		}
+		if parallel.String() != serial.String() {
+			t.Fatalf("results not match\nGot:\n%v\nExpected:\n%v", parallel, serial)
+		}
+	}
+}
+
+// makeWideTree creates levels of directories with width subdirectories and files each
+func makeWideTree(b *testing.B, levels, width int) string {
+	b.Helper()
+	root := b.TempDir()
+	var fill func(dir string, level int)
+	fill = func(dir string, level int) {
+		for i := 0; i < width; i++ {
+			name := filepath.Join(dir, "f"+strconv.Itoa(i)+".txt")
+			if err := os.WriteFile(name, []byte(name), 0644); err != nil {
+				b.Fatal(err)
+			}
+			if level < levels {
+				sub := filepath.Join(dir, "d"+strconv.Itoa(i))
+				if err := os.Mkdir(sub, 0755); err != nil {
+					b.Fatal(err)
+				}
+				fill(sub, level+1)
+			}
+		}
+	}
+	fill(root, 1)
+	return root
+}
+
+func BenchmarkWalk(b *testing.B) {
+	root := makeWideTree(b, 4, 6)
+	for _, workers := range []int{0, 4, 16} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			opts := Options{Files: true, Workers: workers}
+			for i := 0; i < b.N; i++ {
+				if err := WriteDir(io.Discard, root, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestTreeMeta(t *testing.T) {
+	fsys := fstest.MapFS{
+		"bin/app":      {Data: []byte("hello"), Mode: 0755, ModTime: time.Date(2024, 10, 8, 13, 0, 0, 0, time.UTC), Sys: &tar.Header{Uname: "deploy", Gname: "www"}},
+		"bin/app.conf": {Mode: 0600, ModTime: time.Date(2023, 1, 2, 3, 4, 0, 0, time.UTC), Sys: &tar.Header{Uid: 54321, Gid: 54321}},
+	}
+	expected := `├───[-rwxr-xr-x deploy www   2024-10-08] app (5b)
+└───[-rw------- 54321  54321 2023-01-02] app.conf (empty)
+`
+	out := new(bytes.Buffer)
+	opts := Options{Files: true, Perms: true, Owner: true, Group: true, Date: true, TimeFormat: "2006-01-02"}
+	if err := Write(out, fsys, "bin", opts); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != expected {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+func TestTreeLinkMeta(t *testing.T) {
+	root := makeTree(t, map[string]string{"c/inc.txt": "inc"})
+	for name, target := range map[string]string{"lc": "c", "gone": "missing"} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := Tree(DirFS(root), ".", Options{Files: true, Perms: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the columns describe the links, not what they point to
+	links := 0
+	for _, n := range tree.Children {
+		if n.Target == "" {
+			continue
+		}
+		links++
+		if n.Mode != "Lrwxrwxrwx" {
+			t.Errorf("unexpected mode of %s: %q", n.Name, n.Mode)
+		}
+	}
+	if links != 2 {
+		t.Errorf("expected 2 links, got %d", links)
+	}
+}
+
+// syncBuffer lets a test read what a running Watch wrote so far
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWatch(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		root := makeTree(t, map[string]string{"logs/app.log": "start\n"})
+		ctx, cancel := context.WithCancel(context.Background())
+		out := &syncBuffer{}
+		done := make(chan error)
+		go func() {
+			done <- Watch(ctx, out, root, Options{Files: true}, WatchOptions{Interval: 20 * time.Millisecond, Diff: true, Poll: poll})
+		}()
+
+		waitFor(t, out, "└───app.log (6b)\n")
+		os.Mkdir(filepath.Join(root, "cache"), 0755)
+		waitFor(t, out, "+ └───cache\n")
+		// replaced in one step so no half written state is seen
+		tmp := filepath.Join(t.TempDir(), "app.log")
+		os.WriteFile(tmp, []byte("start\nstop\n"), 0644)
+		os.Rename(tmp, filepath.Join(root, "logs", "app.log"))
+		waitFor(t, out, "  └───logs\n~ \t└───app.log (6b -> 11b)\n")
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watch (poll=%v) failed: %v", poll, err)
+		}
+	}
+}
+
+func waitFor(t *testing.T, out *syncBuffer, expected string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), expected) {
+		if time.Now().After(deadline) {
+			t.Fatalf("results not match\nGot:\n%v\nExpected to contain:\n%v", out.String(), expected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScaffold(t *testing.T) {
+	expected := new(bytes.Buffer)
+	if err := WriteDir(expected, "../testdata", Options{Files: true}); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := Parse(bytes.NewReader(expected.Bytes()))
+	if err != nil {
+		t.Fatalf("cant parse tree: %v", err)
+	}
+	root := filepath.Join(t.TempDir(), "copy")
+	if err := Scaffold(root, tree); err != nil {
+		t.Fatalf("cant scaffold tree: %v", err)
+	}
+	out := new(bytes.Buffer)
+	if err := WriteDir(out, root, Options{Files: true}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != expected.String() {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+	if err := Scaffold(root, tree); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("existing files must not be overwritten, got: %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		text string
+		line int
+		msg  string
+	}{
+		{"├───a (1b)\n", 1, `"." has no entry drawn with "└───"`},
+		{"└───a (1b)\n└───b (1b)\n", 2, `entry after the last entry of "."`},
+		{"└───a (1b)\n\t└───b (1b)\n", 2, "indented deeper than its parent"},
+		{"├───a\n\t└───b (1b)\n└───c\n", 2, `indent "\t" does not match the connector of "a"`},
+		{"└───a\n│\t└───b (1b)\n", 2, `indent "│\t" does not match the connector of "a"`},
+		{"├───a (1b)\n\n└───b\n", 2, "empty line"},
+		{"├───a\n└───a\n", 2, `duplicate entry "a"`},
+		{"└───a/b (empty)\n", 1, `invalid name "a/b"`},
+		{"└── a\n", 1, `expected "├───" or "└───"`},
+	}
+	for _, c := range cases {
+		_, err := Parse(strings.NewReader(c.text))
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Line != c.line || perr.Msg != c.msg {
+			t.Errorf("parse %q: got %v, expected line %d: %s", c.text, err, c.line, c.msg)
+		}
+	}
+}
+
+const testVerifyResult = `missing: b.txt
+extra: d.txt
+modified: a.txt (size, sha256)
+modified: sub/c.txt (sha256)
+3 files checked
+`
+
+func TestManifestVerify(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"a.txt":     "alpha",
+		"b.txt":     "beta",
+		"sub/c.txt": "gamma",
+	})
+	manifest := new(bytes.Buffer)
+	if err := WriteManifest(manifest, root, Options{}); err != nil {
+		t.Fatalf("cant write manifest: %v", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(manifest.Bytes(), &m); err != nil {
+		t.Fatalf("cant decode manifest: %v", err)
+	}
+	// sha256 of "gamma"
+	c := ManifestEntry{Path: "sub/c.txt", Size: 5, Mode: "-rw-r--r--", SHA256: "be9d587defa1f0c09ef49eb17e206983a5f8f8289e4281860bd0ee5a19592c67"}
+	if len(m.Files) != 3 || m.Files[2].Path != c.Path || m.Files[2].Size != c.Size || m.Files[2].SHA256 != c.SHA256 {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+
+	out := new(bytes.Buffer)
+	if err := Verify(out, root, bytes.NewReader(manifest.Bytes()), Options{}); err != nil {
+		t.Errorf("unchanged tree must verify, got: %v\n%s", err, out)
+	}
+
+	for name, content := range map[string]string{"a.txt": "alpha!", "sub/c.txt": "gammA", "d.txt": "delta"} {
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(root, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	err := Verify(out, root, bytes.NewReader(manifest.Bytes()), Options{})
+	if result := out.String(); result != testVerifyResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testVerifyResult)
+	}
+	var verifyErr *VerifyError
+	if !errors.As(err, &verifyErr) || len(verifyErr.Missing) != 1 || len(verifyErr.Extra) != 1 || len(verifyErr.Modified) != 2 {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestManifestIgnoresDisplayOptions(t *testing.T) {
+	root := makeTree(t, map[string]string{"a.txt": "alpha", "sub/deep/b.txt": "beta"})
+	m, err := BuildManifest(DirFS(root), ".", Options{Depth: 1, Prune: true, Mime: true, Du: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 2 || m.Files[1].Path != "sub/deep/b.txt" {
+		t.Errorf("the depth limit must not apply to manifests: %+v", m)
+	}
+}
+
+func TestManifestSymlink(t *testing.T) {
+	root := makeTree(t, map[string]string{"a.txt": "alpha", "b.txt": "beta"})
+	link := filepath.Join(root, "current")
+	if err := os.Symlink("a.txt", link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	m, err := BuildManifest(DirFS(root), ".", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ManifestEntry{Path: "current", Mode: "Lrwxrwxrwx", Target: "a.txt"}
+	if len(m.Files) != 3 || m.Files[2] != expected {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+
+	manifest := new(bytes.Buffer)
+	if err := json.NewEncoder(manifest).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(link)
+	if err := os.Symlink("b.txt", link); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	Verify(out, root, manifest, Options{})
+	if result, expected := out.String(), "modified: current (target)\n3 files checked\n"; result != expected {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
+
+const testMimeResult = `├───a_lorem
+│	├───dolor.txt (empty) [text/plain]
+│	├───gopher.png (70372b) [image/png]
+│	└───ipsum
+│		└───gopher.png (70372b) [image/png]
+├───css
+│	└───body.css (28b) [text/plain]
+├───empty.txt (empty) [text/plain]
+├───html
+│	└───index.html (57b) [text/html]
+├───js
+│	└───site.js (10b) [text/plain]
+└───z_lorem
+	├───dolor.txt (empty) [text/plain]
+	├───gopher.png (70372b) [image/png]
+	└───ipsum
+		└───gopher.png (70372b) [image/png]
+
+image/png: 4 files, 281488b
+text/html: 1 file, 57b
+text/plain: 5 files, 38b
+`
+
+func TestTreeMime(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := WriteDir(out, "../testdata/static", Options{Files: true, Mime: true, Types: true}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testMimeResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testMimeResult)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"docs/readme.md":   "# docs",
+		"docs/api/spec.md": "spec",
+		"src/main.go":      "package main",
+	})
+	if err := os.Symlink("..", filepath.Join(root, "src", "up")); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(Handler(DirFS(root), ".", Options{}))
+	defer srv.Close()
+
+	get := func(url string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/docs?format=json&f=1&L=1")
+	var node Node
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &node) != nil {
+		t.Fatalf("unexpected response %d: %s", code, body)
+	}
+	if node.Name != "docs" || len(node.Children) != 2 || len(node.Children[0].Children) != 0 || node.Children[1].Name != "readme.md" {
+		t.Errorf("unexpected tree: %s", body)
+	}
+
+	code, body = get("/?f&I=*.md")
+	if code != http.StatusOK || !strings.Contains(body, "<details") || !strings.Contains(body, "main.go") || strings.Contains(body, "readme.md") {
+		t.Errorf("unexpected html response %d: %s", code, body)
+	}
+
+	for url, expected := range map[string]int{
+		"/src/up":            http.StatusForbidden,
+		"/src/up/docs":       http.StatusForbidden,
+		"/%2e%2e":            http.StatusBadRequest,
+		"/missing":           http.StatusNotFound,
+		"/src/main.go":       http.StatusNotFound,
+		"/?L=-1":             http.StatusBadRequest,
+		"/?format=yaml":      http.StatusBadRequest,
+		"/?sort=random&f=no": http.StatusBadRequest,
+	} {
+		if code, body := get(url); code != expected {
+			t.Errorf("%s: got %d, expected %d: %s", url, code, expected, body)
+		}
+	}
+}
+
+func TestHandlerFollowLinks(t *testing.T) {
+	root := makeTree(t, map[string]string{"docs/readme.md": "# docs"})
+	outside := makeTree(t, map[string]string{"secret.txt": "secret"})
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(Handler(DirFS(root), ".", Options{Files: true, FollowLinks: true}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/?format=text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || strings.Contains(string(body), "secret.txt") || !strings.Contains(string(body), "out -> ") {
+		t.Errorf("links must not be followed out of the root, got %d: %s", resp.StatusCode, body)
+	}
+
+	// render errors are reported instead of a truncated page
+	failing := Options{Visitor: VisitorFunc(func(n *Node, depth int) error {
+		return errors.New("visit failed")
+	})}
+	srv2 := httptest.NewServer(Handler(DirFS(root), ".", failing))
+	defer srv2.Close()
+	resp, err = http.Get(srv2.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got %d, expected %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+}
+
+const testLocResult = `├───logo.png (12b)
+├───notes.txt (6b) [2 lines: 1 code, 0 comment, 1 blank]
+└───src [10 lines: 3 code, 5 comment, 2 blank]
+	├───main.go (58b) [7 lines: 2 code, 4 comment, 1 blank]
+	└───run.sh (19b) [3 lines: 1 code, 1 comment, 1 blank]
+
+Go: 1 file, 7 lines: 2 code, 4 comment, 1 blank
+Shell: 1 file, 3 lines: 1 code, 1 comment, 1 blank
+Text: 1 file, 2 lines: 1 code, 0 comment, 1 blank
+total: 3 files, 12 lines: 4 code, 5 comment, 3 blank
+`
+
+func TestTreeLoc(t *testing.T) {
+	root := makeTree(t, map[string]string{
+		"logo.png":    "\x89PNG\r\n\x1a\n\x00\x00\x00\x00",
+		"notes.txt":   "todo\n\n",
+		"src/main.go": "// Package main\npackage main\n\n/*\n  runs\n*/\nfunc main() {}\n",
+		"src/run.sh":  "#!/bin/sh\necho hi\n\n",
+	})
+	out := new(bytes.Buffer)
+	if err := WriteDir(out, root, Options{Files: true, Loc: true}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testLocResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testLocResult)
+	}
+}
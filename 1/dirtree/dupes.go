@@ -0,0 +1,136 @@
+package dirtree
+
+import (
+	"io/fs"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// dupeGroup is a set of files with identical contents
+type dupeGroup struct {
+	size  int64
+	files []*Node
+}
+
+// wasted is the space taken by all copies but one
+func (g dupeGroup) wasted() int64 {
+	return g.size * int64(len(g.files)-1)
+}
+
+// findDupes hashes files sharing their size with another file in parallel
+// and groups them by content, largest waste first; empty files are skipped,
+// and so are files seen before through a followed link or a hard link
+func (w *walker) findDupes(root *Node) []dupeGroup {
+	bySize := map[int64][]*Node{}
+	seen := map[string]bool{}
+	collectFiles(root, func(n *Node) {
+		if n.Type != typeFile || n.info == nil || n.Size == 0 {
+			return
+		}
+		// the same file reached twice wastes no space
+		if key := dirKey(w.fsys, n.fsPath, n.info); key != "" {
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+		}
+		bySize[n.Size] = append(bySize[n.Size], n)
+	})
+	var candidates []*Node
+	for _, files := range bySize {
+		if len(files) > 1 {
+			candidates = append(candidates, files...)
+		}
+	}
+
+	sums, errs := hashFiles(w.fsys, candidates)
+	byHash := map[string]*dupeGroup{}
+	for idx, n := range candidates {
+		if errs[idx] != nil {
+			w.fail(n, "error hashing", errs[idx])
+			continue
+		}
+		key := strconv.FormatInt(n.Size, 10) + ":" + sums[idx]
+		if byHash[key] == nil {
+			byHash[key] = &dupeGroup{size: n.Size}
+		}
+		byHash[key].files = append(byHash[key].files, n)
+	}
+
+	var groups []dupeGroup
+	for _, g := range byHash {
+		if len(g.files) < 2 {
+			continue
+		}
+		sort.Slice(g.files, func(i, j int) bool { return g.files[i].fsPath < g.files[j].fsPath })
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].wasted() != groups[j].wasted() {
+			return groups[i].wasted() > groups[j].wasted()
+		}
+		return groups[i].files[0].fsPath < groups[j].files[0].fsPath
+	})
+	for i, g := range groups {
+		for _, n := range g.files {
+			n.DupeGroup = i + 1
+		}
+	}
+	return groups
+}
+
+// hashFiles computes the SHA-256 of files in parallel, the results are in the same order
+func hashFiles(fsys fs.FS, files []*Node) ([]string, []error) {
+	sums := make([]string, len(files))
+	errs := make([]error, len(files))
+	parallel(len(files), func(idx int) {
+		sums[idx], errs[idx] = fileHash(fsys, files[idx].fsPath)
+	})
+	return sums, errs
+}
+
+// parallel calls fn for every index below count on runtime.NumCPU workers
+func parallel(count int, fn func(idx int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				fn(idx)
+			}
+		}()
+	}
+	for idx := 0; idx < count; idx++ {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// collectFiles calls fn for every node below n
+func collectFiles(n *Node, fn func(*Node)) {
+	for _, child := range n.Children {
+		fn(child)
+		collectFiles(child, fn)
+	}
+}
+
+func dupesText(groups []dupeGroup, opts Options) string {
+	var sb strings.Builder
+	var total int64
+	for i, g := range groups {
+		total += g.wasted()
+		sb.WriteString("dupe #" + strconv.Itoa(i+1) + ": " + plural(len(g.files), "copy", "copies") +
+			" of " + sizeText(g.size, opts) + ", wasted " + sizeText(g.wasted(), opts) + "\n")
+		for _, n := range g.files {
+			sb.WriteString("\t" + n.fsPath + "\n")
+		}
+	}
+	sb.WriteString(plural(len(groups), "duplicate group", "duplicate groups") + ", wasted " + summarySize(total, opts) + "\n")
+	return sb.String()
+}
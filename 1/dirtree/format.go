@@ -0,0 +1,250 @@
+package dirtree
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formatter renders a built tree
+type Formatter interface {
+	Format(out io.Writer, root *Node, opts Options) error
+}
+
+// FormatterFunc adapts a function to the Formatter interface
+type FormatterFunc func(out io.Writer, root *Node, opts Options) error
+
+func (f FormatterFunc) Format(out io.Writer, root *Node, opts Options) error {
+	return f(out, root, opts)
+}
+
+// dataFormatter is a built in formatter publishing directory totals as fields,
+// the whole tree is walked for it whatever the depth limit
+type dataFormatter struct {
+	FormatterFunc
+}
+
+// Built in formatters; Text is the default box-drawing output
+var (
+	Text Formatter = FormatterFunc(writeText)
+	JSON Formatter = dataFormatter{writeJSON}
+	XML  Formatter = dataFormatter{writeXML}
+	HTML Formatter = FormatterFunc(writeHTML)
+)
+
+// Formats maps format names to the built in formatters
+var Formats = map[string]Formatter{
+	"text": Text,
+	"json": JSON,
+	"xml":  XML,
+	"html": HTML,
+}
+
+func writeText(out io.Writer, root *Node, opts Options) error {
+	var sb strings.Builder
+	var widths []int
+	if opts.wantMeta() {
+		widths = metaWidths(root, opts)
+	}
+	textLevel(&sb, root.Children, "", opts, widths)
+	if opts.Summary {
+		sb.WriteString("\n" + summaryText(root, opts) + "\n")
+	}
+	if opts.Types {
+		sb.WriteString("\n" + typesText(root.types, opts))
+	}
+	if opts.Loc {
+		sb.WriteString("\n" + langsText(root.langs))
+	}
+	if opts.Dupes {
+		sb.WriteString("\n" + dupesText(root.dupes, opts))
+	}
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
+
+// textLevel writes nodes below prefix; widths align the metadata columns
+func textLevel(sb *strings.Builder, nodes []*Node, prefix string, opts Options, widths []int) {
+	for i, n := range nodes {
+		last := i == len(nodes)-1
+		color := ""
+		if opts.diff {
+			if opts.Color {
+				color = diffColors[n.Status]
+				sb.WriteString(color)
+			}
+			sb.WriteString(diffMarkers[n.Status])
+		}
+		sb.WriteString(prefix)
+		if last {
+			sb.WriteString("└───")
+		} else {
+			sb.WriteString("├───")
+		}
+		sb.WriteString(metaText(n, opts, widths))
+		sb.WriteString(label(n, opts))
+		if color != "" {
+			sb.WriteString(colorReset)
+		}
+		sb.WriteString("\n")
+
+		if n.IsDir() {
+			newPrefix := prefix
+			if last {
+				newPrefix += "\t"
+			} else {
+				newPrefix += "│\t"
+			}
+			textLevel(sb, n.Children, newPrefix, opts, widths)
+		}
+	}
+}
+
+// label is the entry name with its annotations, as shown by text and html
+func label(n *Node, opts Options) string {
+	text := entryLabel(n, opts)
+	if opts.Mime && n.MimeType != "" {
+		text += " [" + n.MimeType + "]"
+	}
+	if opts.Loc && n.Lines > 0 {
+		text += " [" + locText(lineCounts{n.Lines, n.Blank, n.Comments}) + "]"
+	}
+	if n.DupeGroup > 0 {
+		text += " [dupe #" + strconv.Itoa(n.DupeGroup) + "]"
+	}
+	if n.Err != "" {
+		text += " [" + n.Err + "]"
+	}
+	return text
+}
+
+func entryLabel(n *Node, opts Options) string {
+	name := n.Name
+	if n.Target != "" {
+		name += " -> " + n.Target
+	}
+	switch {
+	case n.Dangling:
+		return name + " [dangling]"
+	case n.Recursive:
+		return name + " [recursive, not followed]"
+	case n.IsDir():
+		if !opts.Du {
+			return name
+		}
+		return name + " (" + sizeText(n.Size, opts) + ", " + plural(n.Files, "file", "files") + ")"
+	case n.Type == typeLink || n.info == nil:
+		return name
+	case n.Status == statusChanged && n.OldSize != n.Size:
+		return name + " (" + sizeText(n.OldSize, opts) + " -> " + sizeText(n.Size, opts) + ")"
+	}
+	return name + " (" + sizeText(n.Size, opts) + ")"
+}
+
+func sizeText(size int64, opts Options) string {
+	if size == 0 {
+		return "empty"
+	}
+	if opts.Human {
+		return humanSize(size)
+	}
+	return strconv.FormatInt(size, 10) + "b"
+}
+
+// humanSize formats size with binary units, one decimal above bytes
+func humanSize(size int64) string {
+	const units = "KMGTPE"
+	if size < 1024 {
+		return strconv.FormatInt(size, 10) + "b"
+	}
+	value := float64(size) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + units[unit:unit+1] + "iB"
+}
+
+func plural(count int, one, many string) string {
+	if count == 1 {
+		return "1 " + one
+	}
+	return strconv.Itoa(count) + " " + many
+}
+
+func summaryText(root *Node, opts Options) string {
+	text := plural(root.Dirs, "directory", "directories") + ", " + plural(root.Files, "file", "files")
+	if root.Links > 0 {
+		text += ", " + plural(root.Links, "link", "links")
+	}
+	return text + ", total size " + summarySize(root.Size, opts)
+}
+
+// summarySize is sizeText for totals, which are never shown as "empty"
+func summarySize(size int64, opts Options) string {
+	if opts.Human {
+		return humanSize(size)
+	}
+	return strconv.FormatInt(size, 10) + "b"
+}
+
+func writeJSON(out io.Writer, root *Node, opts Options) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(root)
+}
+
+func writeXML(out io.Writer, root *Node, opts Options) error {
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
+
+var htmlTemplate = template.Must(template.New("tree").Funcs(template.FuncMap{
+	"label": func(*Node) string { return "" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+ul { list-style: none; padding-left: 1.5em; }
+summary { cursor: pointer; }
+.added { color: green; }
+.removed { color: red; text-decoration: line-through; }
+.changed { color: darkorange; }
+</style>
+</head>
+<body>
+<details open><summary>{{.Name}}</summary>
+{{template "children" .Children}}
+</details>
+</body>
+</html>
+{{define "children"}}{{if .}}<ul>
+{{range .}}<li{{with .Status}} class="{{.}}"{{end}}>{{if .IsDir}}<details><summary>{{label .}}</summary>
+{{template "children" .Children}}</details>{{else}}{{label .}}{{end}}</li>
+{{end}}</ul>
+{{end}}{{end}}`))
+
+func writeHTML(out io.Writer, root *Node, opts Options) error {
+	tmpl, err := htmlTemplate.Clone()
+	if err != nil {
+		return err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"label": func(n *Node) string { return metaText(n, opts, nil) + label(n, opts) },
+	})
+	return tmpl.Execute(out, root)
+}
@@ -0,0 +1,336 @@
+package dirtree
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// linkFS is implemented by file systems that can report symlink targets,
+// Stat on them is expected to follow links
+type linkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+}
+
+// osFS is os.DirFS that also reads symlinks
+type osFS struct {
+	fs.FS
+	dir string
+}
+
+func newOSFS(dir string) osFS {
+	return osFS{FS: os.DirFS(dir), dir: dir}
+}
+
+// DirFS is os.DirFS that also reports symlink targets, so they are shown in the tree
+func DirFS(dir string) fs.FS {
+	return newOSFS(dir)
+}
+
+// path converts a name in the file system into an OS path
+func (f osFS) path(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", fs.ErrInvalid
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(name)), nil
+}
+
+func (f osFS) ReadLink(name string) (string, error) {
+	full, err := f.path(name)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return os.Readlink(full)
+}
+
+// tarFS is a read-only file system over the headers of a tar archive, sizes and
+// modes come from the headers and file contents are only kept if they were read;
+// symlinks are listed with their targets but never followed
+type tarFS struct {
+	entries map[string]*tarEntry
+}
+
+// tarEntry is one file of the archive or a directory implied by the paths below it
+type tarEntry struct {
+	// hdr is nil for implied directories
+	hdr      *tar.Header
+	data     []byte
+	loaded   bool
+	children map[string]bool
+}
+
+// errNoContents is returned reading a file of an archive opened without its contents
+var errNoContents = errors.New("contents not loaded")
+
+func (f tarFS) entry(op, name string) (*tarEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := f.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (f tarFS) Open(name string) (fs.File, error) {
+	e, err := f.entry("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file := &tarFile{fsys: f, name: name, entry: e}
+	if e.loaded {
+		file.r = bytes.NewReader(e.data)
+	}
+	return file, nil
+}
+
+func (f tarFS) Stat(name string) (fs.FileInfo, error) {
+	e, err := f.entry("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return tarInfo{name: path.Base(name), entry: e}, nil
+}
+
+func (f tarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := f.entry("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	names := make([]string, 0, len(e.children))
+	for child := range e.children {
+		names = append(names, child)
+	}
+	sort.Strings(names)
+	entries := make([]fs.DirEntry, len(names))
+	for i, child := range names {
+		entries[i] = fs.FileInfoToDirEntry(tarInfo{name: child, entry: f.entries[path.Join(name, child)]})
+	}
+	return entries, nil
+}
+
+func (f tarFS) ReadLink(name string) (string, error) {
+	e, err := f.entry("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if e.hdr == nil || e.hdr.Typeflag != tar.TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return e.hdr.Linkname, nil
+}
+
+func (e *tarEntry) isDir() bool {
+	return e.hdr == nil || e.hdr.Typeflag == tar.TypeDir
+}
+
+// tarInfo describes an entry, its mode and size are those of the header
+type tarInfo struct {
+	name  string
+	entry *tarEntry
+}
+
+func (i tarInfo) Name() string { return i.name }
+func (i tarInfo) IsDir() bool  { return i.Mode().IsDir() }
+
+func (i tarInfo) Size() int64 {
+	if i.entry.hdr == nil || i.IsDir() {
+		return 0
+	}
+	return i.entry.hdr.Size
+}
+
+func (i tarInfo) Mode() fs.FileMode {
+	if i.entry.hdr == nil {
+		return fs.ModeDir | 0755
+	}
+	return i.entry.hdr.FileInfo().Mode()
+}
+
+func (i tarInfo) ModTime() time.Time {
+	if i.entry.hdr == nil {
+		return time.Time{}
+	}
+	return i.entry.hdr.ModTime
+}
+
+func (i tarInfo) Sys() interface{} {
+	if i.entry.hdr == nil {
+		return nil
+	}
+	return i.entry.hdr
+}
+
+// tarFile is an opened entry of a tarFS
+type tarFile struct {
+	fsys  tarFS
+	name  string
+	entry *tarEntry
+	// r is nil when the contents were not read
+	r *bytes.Reader
+	// dirRead is the number of directory entries returned by ReadDir so far
+	dirRead int
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) {
+	return tarInfo{name: path.Base(f.name), entry: f.entry}, nil
+}
+
+func (f *tarFile) Read(b []byte) (int, error) {
+	if f.entry.isDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	if f.r == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errNoContents}
+	}
+	return f.r.Read(b)
+}
+
+func (f *tarFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.fsys.ReadDir(f.name)
+	if err != nil {
+		return nil, err
+	}
+	entries = entries[f.dirRead:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(entries) {
+			entries = entries[:n]
+		}
+	}
+	f.dirRead += len(entries)
+	return entries, nil
+}
+
+func (f *tarFile) Close() error {
+	return nil
+}
+
+// readTar lists the entries of a tar archive, their bodies are read only if contents is set
+func readTar(r io.Reader, contents bool) (tarFS, error) {
+	f := tarFS{entries: map[string]*tarEntry{".": {children: map[string]bool{}}}}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return f, nil
+		}
+		if err != nil {
+			return tarFS{}, err
+		}
+		name := strings.TrimSuffix(path.Clean(strings.TrimPrefix(hdr.Name, "./")), "/")
+		if name == "." || !fs.ValidPath(name) {
+			continue
+		}
+		e := f.add(name)
+		e.hdr = hdr
+		if contents && hdr.Typeflag == tar.TypeReg {
+			if e.data, err = io.ReadAll(tr); err != nil {
+				return tarFS{}, err
+			}
+			e.loaded = true
+		}
+	}
+}
+
+// add returns the entry of name, creating it and its missing parent directories
+func (f tarFS) add(name string) *tarEntry {
+	if e, ok := f.entries[name]; ok {
+		return e
+	}
+	e := &tarEntry{children: map[string]bool{}}
+	f.entries[name] = e
+	f.add(path.Dir(name)).children[path.Base(name)] = true
+	return e
+}
+
+// maxLinkTarget bounds the symlink targets read from zip archives
+const maxLinkTarget = 4096
+
+// zipFS is a zip archive that also reports symlink targets,
+// which zip stores as the contents of the link entries
+type zipFS struct {
+	*zip.Reader
+}
+
+func (f zipFS) ReadLink(name string) (string, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	target, err := io.ReadAll(io.LimitReader(file, maxLinkTarget))
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return string(target), nil
+}
+
+// OpenArchive opens a .zip, .tar, .tar.gz or .tgz file as a file system,
+// the closer must be called once the file system is no longer used
+func OpenArchive(name string) (fs.FS, io.Closer, error) {
+	return openArchive(name, true)
+}
+
+// openArchive opens an archive; the contents of tar archives are read into memory
+// only if contents is set, otherwise just their headers are kept
+func openArchive(name string, contents bool) (fs.FS, io.Closer, error) {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".zip") {
+		r, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zipFS{&r.Reader}, r, nil
+	}
+
+	gzipped := strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+	if !gzipped && !strings.HasSuffix(lower, ".tar") {
+		return nil, nil, fmt.Errorf("unknown archive type %q", filepath.Base(name))
+	}
+	// the archive is read at once, the file is kept only to be closed by the caller
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r = gz
+	}
+	tfs, err := readTar(r, contents)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %v", filepath.Base(name), err)
+	}
+	return tfs, f, nil
+}
@@ -0,0 +1,169 @@
+package dirtree
+
+import (
+	"bufio"
+	"io/fs"
+	"regexp"
+	"strings"
+)
+
+// pattern is a single compiled line of a gitignore file
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreList is an ordered set of patterns; paths are matched relative to base,
+// which is a slash separated path from the walk root ("" for the root itself)
+type ignoreList struct {
+	base     string
+	patterns []pattern
+}
+
+// newIgnoreList compiles gitignore lines, skipping blanks and comments
+func newIgnoreList(base string, lines []string) *ignoreList {
+	l := &ignoreList{base: base}
+	for _, line := range lines {
+		if p, ok := parsePattern(line); ok {
+			l.patterns = append(l.patterns, p)
+		}
+	}
+	return l
+}
+
+// readIgnoreFile loads a .gitignore file, returning nil if it can't be read
+func readIgnoreFile(fsys fs.FS, name, base string) *ignoreList {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return newIgnoreList(base, lines)
+}
+
+func parsePattern(line string) (pattern, bool) {
+	var p pattern
+	line = trimTrailingSpaces(line)
+	if line == "" || line[0] == '#' {
+		return p, false
+	}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' && len(line) > 1 && (line[1] == '#' || line[1] == '!') {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+
+	// a slash anywhere but the end anchors the pattern to the ignore file's directory,
+	// otherwise it matches a name at any depth
+	prefix := "^(?:.*/)?"
+	if strings.Contains(line, "/") {
+		prefix = "^"
+		line = strings.TrimPrefix(line, "/")
+	}
+	re, err := regexp.Compile(prefix + globToRegexp(line) + "$")
+	if err != nil {
+		return p, false
+	}
+	p.re = re
+	return p, true
+}
+
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			atStart := i == 0 || glob[i-1] == '/'
+			switch {
+			case atStart && i+2 < len(glob) && glob[i+2] == '/':
+				// "**/" matches zero or more directories
+				sb.WriteString("(?:.*/)?")
+				i += 2
+			case atStart && i+2 == len(glob):
+				// trailing "/**" matches everything inside
+				sb.WriteString(".*")
+				i++
+			default:
+				sb.WriteString("[^/]*")
+				i++
+			}
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// match reports whether any pattern matched rel and, if so, whether the last
+// matching one ignores it
+func (l *ignoreList) match(rel string, isDir bool) (matched, ignored bool) {
+	if l.base != "" {
+		if !strings.HasPrefix(rel, l.base+"/") {
+			return false, false
+		}
+		rel = rel[len(l.base)+1:]
+	}
+	for _, p := range l.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			matched, ignored = true, !p.negate
+		}
+	}
+	return matched, ignored
+}
+
+// isIgnored applies the lists from the outermost to the innermost,
+// so later lists override earlier ones
+func isIgnored(lists []*ignoreList, rel string, isDir bool) bool {
+	ignored := false
+	for _, l := range lists {
+		if m, ign := l.match(rel, isDir); m {
+			ignored = ign
+		}
+	}
+	return ignored
+}
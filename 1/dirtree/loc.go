@@ -0,0 +1,204 @@
+package dirtree
+
+import (
+	"bufio"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// language describes the comment syntax of a source language;
+// either kind of comment may be missing
+type language struct {
+	name       string
+	line       []string
+	blockStart string
+	blockEnd   string
+}
+
+var (
+	langC    = []string{"//"}
+	langHash = []string{"#"}
+)
+
+// languages maps lower case file extensions to their language
+var languages = map[string]language{
+	".go":    {name: "Go", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".c":     {name: "C", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".h":     {name: "C", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".cc":    {name: "C++", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".cpp":   {name: "C++", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".hpp":   {name: "C++", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".java":  {name: "Java", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".kt":    {name: "Kotlin", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".rs":    {name: "Rust", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".swift": {name: "Swift", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".js":    {name: "JavaScript", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".ts":    {name: "TypeScript", line: langC, blockStart: "/*", blockEnd: "*/"},
+	".css":   {name: "CSS", blockStart: "/*", blockEnd: "*/"},
+	".html":  {name: "HTML", blockStart: "<!--", blockEnd: "-->"},
+	".xml":   {name: "XML", blockStart: "<!--", blockEnd: "-->"},
+	".md":    {name: "Markdown", blockStart: "<!--", blockEnd: "-->"},
+	".py":    {name: "Python", line: langHash},
+	".rb":    {name: "Ruby", line: langHash},
+	".sh":    {name: "Shell", line: langHash},
+	".yaml":  {name: "YAML", line: langHash},
+	".yml":   {name: "YAML", line: langHash},
+	".toml":  {name: "TOML", line: langHash},
+	".sql":   {name: "SQL", line: []string{"--"}, blockStart: "/*", blockEnd: "*/"},
+}
+
+// plainText is used for text files of no known language, all their lines are code
+var plainText = language{name: "Text"}
+
+// lineCounts holds the line totals of a file or subtree
+type lineCounts struct {
+	lines, blank, comments int
+}
+
+// countLines fills the line counts of every text file in parallel;
+// files sniffed as binary are skipped
+func (w *walker) countLines(root *Node) {
+	var files []*Node
+	collectFiles(root, func(n *Node) {
+		if n.Type == typeFile && n.info != nil && isText(n.MimeType) {
+			files = append(files, n)
+		}
+	})
+	errs := make([]error, len(files))
+	parallel(len(files), func(idx int) {
+		n := files[idx]
+		lang := languageOf(n.Name)
+		var c lineCounts
+		c, errs[idx] = countFile(w.fsys, n.fsPath, lang)
+		n.Language = lang.name
+		n.Lines, n.Blank, n.Comments = c.lines, c.blank, c.comments
+	})
+	for idx, err := range errs {
+		if err != nil {
+			w.fail(files[idx], "error reading", err)
+		}
+	}
+	sumLines(root)
+}
+
+func languageOf(name string) language {
+	if lang, ok := languages[strings.ToLower(path.Ext(name))]; ok {
+		return lang
+	}
+	return plainText
+}
+
+// countFile classifies every line of a file as blank, comment or code; a line
+// is a comment if it starts with a comment or lies inside a block comment
+func countFile(fsys fs.FS, name string, lang language) (lineCounts, error) {
+	var c lineCounts
+	f, err := fsys.Open(name)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	inBlock := false
+	for scanner.Scan() {
+		c.lines++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case inBlock:
+			c.comments++
+			inBlock = !strings.Contains(line, lang.blockEnd)
+		case line == "":
+			c.blank++
+		case hasAnyPrefix(line, lang.line):
+			c.comments++
+		case lang.blockStart != "" && strings.HasPrefix(line, lang.blockStart):
+			c.comments++
+			inBlock = !strings.Contains(line[len(lang.blockStart):], lang.blockEnd)
+		}
+	}
+	return c, scanner.Err()
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sumLines rolls the line counts of files up onto their directories
+func sumLines(n *Node) {
+	if !n.IsDir() {
+		return
+	}
+	n.Lines, n.Blank, n.Comments = 0, 0, 0
+	for _, child := range n.Children {
+		sumLines(child)
+		n.Lines += child.Lines
+		n.Blank += child.Blank
+		n.Comments += child.Comments
+	}
+}
+
+// langStat is the number of files and line totals of one language
+type langStat struct {
+	name  string
+	files int
+	lineCounts
+}
+
+// langStats groups the counted files below root by language, most lines first
+func langStats(root *Node) []langStat {
+	byLang := map[string]*langStat{}
+	collectFiles(root, func(n *Node) {
+		if n.Language == "" {
+			return
+		}
+		if byLang[n.Language] == nil {
+			byLang[n.Language] = &langStat{name: n.Language}
+		}
+		s := byLang[n.Language]
+		s.files++
+		s.lines += n.Lines
+		s.blank += n.Blank
+		s.comments += n.Comments
+	})
+	stats := make([]langStat, 0, len(byLang))
+	for _, s := range byLang {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].lines != stats[j].lines {
+			return stats[i].lines > stats[j].lines
+		}
+		return stats[i].name < stats[j].name
+	})
+	return stats
+}
+
+// locText describes line counts as "12 lines: 8 code, 2 comment, 2 blank"
+func locText(c lineCounts) string {
+	code := c.lines - c.blank - c.comments
+	return plural(c.lines, "line", "lines") + ": " + strconv.Itoa(code) + " code, " +
+		strconv.Itoa(c.comments) + " comment, " + strconv.Itoa(c.blank) + " blank"
+}
+
+func langsText(stats []langStat) string {
+	var sb strings.Builder
+	var total lineCounts
+	files := 0
+	for _, s := range stats {
+		sb.WriteString(s.name + ": " + plural(s.files, "file", "files") + ", " + locText(s.lineCounts) + "\n")
+		files += s.files
+		total.lines += s.lines
+		total.blank += s.blank
+		total.comments += s.comments
+	}
+	sb.WriteString("total: " + plural(files, "file", "files") + ", " + locText(total) + "\n")
+	return sb.String()
+}
@@ -0,0 +1,205 @@
+package dirtree
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestEntry records a single file of a manifest, Path is slash separated
+// and relative to the manifest root. Symlinks have a Target instead of a SHA256
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	SHA256 string `json:"sha256,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+// linkMode is the mode recorded for symlinks, whatever they point to
+var linkMode = (fs.ModeSymlink | fs.ModePerm).String()
+
+// Manifest lists every file of a tree sorted by path
+type Manifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// BuildManifest walks the whole of dir in fsys and hashes every regular file;
+// symlinks are recorded with their targets unless followed into directories.
+// Only the entries to walk are taken from opts: Exclude, Include, Gitignore,
+// FollowLinks and Workers. The returned error holds the entries that could not
+// be read, as with Tree; no manifest is returned if dir itself could not be read
+func BuildManifest(fsys fs.FS, dir string, opts Options) (*Manifest, error) {
+	opts = Options{
+		Files:       true,
+		Exclude:     opts.Exclude,
+		Include:     opts.Include,
+		Gitignore:   opts.Gitignore,
+		FollowLinks: opts.FollowLinks,
+		Workers:     opts.Workers,
+		errRoot:     opts.errRoot,
+	}
+	root, err := buildTree(fsys, dir, path.Base(dir), opts)
+	if root == nil || root.Err != "" {
+		return nil, err
+	}
+	m := &Manifest{}
+	var files []*Node
+	collectFiles(root, func(n *Node) {
+		switch {
+		case n.Type == typeFile && n.info != nil:
+			files = append(files, n)
+		case n.Type == typeLink && n.Err == "":
+			m.Files = append(m.Files, ManifestEntry{
+				Path:   manifestPath(dir, n.fsPath),
+				Mode:   linkMode,
+				Target: n.Target,
+			})
+		}
+	})
+	sums, errs := hashFiles(fsys, files)
+	var hashErrs WalkErrors
+	for i, n := range files {
+		if errs[i] != nil {
+			var pathErr *fs.PathError
+			if errors.As(errs[i], &pathErr) {
+				errs[i] = pathErr.Err
+			}
+			hashErrs = append(hashErrs, fmt.Errorf("%s: error hashing: %w", opts.errPath(n.fsPath), errs[i]))
+			continue
+		}
+		m.Files = append(m.Files, ManifestEntry{
+			Path:   manifestPath(dir, n.fsPath),
+			Size:   n.Size,
+			Mode:   n.info.Mode().String(),
+			SHA256: hex.EncodeToString([]byte(sums[i])),
+		})
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	if len(hashErrs) > 0 {
+		walkErrs, _ := err.(WalkErrors)
+		err = append(walkErrs, hashErrs...)
+	}
+	return m, err
+}
+
+// manifestPath makes the name of an entry in the walked file system relative to dir
+func manifestPath(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return strings.TrimPrefix(name, dir+"/")
+}
+
+// WriteManifest writes the manifest of the directory at an OS path as JSON
+func WriteManifest(out io.Writer, dir string, opts Options) error {
+	opts.errRoot = dir
+	m, err := BuildManifest(newOSFS(dir), ".", opts)
+	if m == nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if encErr := enc.Encode(m); encErr != nil {
+		return encErr
+	}
+	return err
+}
+
+// VerifyError lists the files that differ from a manifest
+type VerifyError struct {
+	Missing  []string
+	Extra    []string
+	Modified []string
+}
+
+func (e *VerifyError) Error() string {
+	return "manifest mismatch: " + strconv.Itoa(len(e.Missing)) + " missing, " +
+		strconv.Itoa(len(e.Extra)) + " extra, " + strconv.Itoa(len(e.Modified)) + " modified"
+}
+
+// Verify compares the directory at an OS path with a JSON manifest read from r
+// and prints one line per missing, extra or modified file. A mismatch is
+// returned as a *VerifyError
+func Verify(out io.Writer, dir string, r io.Reader, opts Options) error {
+	var want Manifest
+	if err := json.NewDecoder(r).Decode(&want); err != nil {
+		return fmt.Errorf("error reading manifest: %w", err)
+	}
+	opts.errRoot = dir
+	got, err := BuildManifest(newOSFS(dir), ".", opts)
+	if got == nil {
+		return err
+	}
+	diff := compareManifests(&want, got)
+
+	var sb strings.Builder
+	for _, p := range diff.Missing {
+		sb.WriteString("missing: " + p + "\n")
+	}
+	for _, p := range diff.Extra {
+		sb.WriteString("extra: " + p + "\n")
+	}
+	for _, p := range diff.Modified {
+		sb.WriteString("modified: " + p + "\n")
+	}
+	sb.WriteString(plural(len(want.Files), "file", "files") + " checked\n")
+	if _, writeErr := io.WriteString(out, sb.String()); writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return err
+	}
+	if len(diff.Missing)+len(diff.Extra)+len(diff.Modified) > 0 {
+		return diff
+	}
+	return nil
+}
+
+// compareManifests matches both file lists by path; a modified entry names
+// the fields that changed, as in "bin/app (size, sha256)"
+func compareManifests(want, got *Manifest) *VerifyError {
+	diff := &VerifyError{}
+	gotByPath := make(map[string]ManifestEntry, len(got.Files))
+	for _, e := range got.Files {
+		gotByPath[e.Path] = e
+	}
+	for _, e := range want.Files {
+		g, ok := gotByPath[e.Path]
+		if !ok {
+			diff.Missing = append(diff.Missing, e.Path)
+			continue
+		}
+		delete(gotByPath, e.Path)
+		var changed []string
+		if g.Size != e.Size {
+			changed = append(changed, "size")
+		}
+		if g.Mode != e.Mode {
+			changed = append(changed, "mode")
+		}
+		if !strings.EqualFold(g.SHA256, e.SHA256) {
+			changed = append(changed, "sha256")
+		}
+		if g.Target != e.Target {
+			changed = append(changed, "target")
+		}
+		if len(changed) > 0 {
+			diff.Modified = append(diff.Modified, e.Path+" ("+strings.Join(changed, ", ")+")")
+		}
+	}
+	for p := range gotByPath {
+		diff.Extra = append(diff.Extra, p)
+	}
+	sort.Strings(diff.Missing)
+	sort.Strings(diff.Extra)
+	sort.Strings(diff.Modified)
+	return diff
+}
@@ -0,0 +1,188 @@
+package dirtree
+
+import (
+	"archive/tar"
+	"io/fs"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultTimeFormat is used for the date column when Options.TimeFormat is empty
+const DefaultTimeFormat = "Jan _2 15:04"
+
+// wantMeta reports whether any metadata column is requested
+func (opts Options) wantMeta() bool {
+	return opts.Perms || opts.Owner || opts.Group || opts.Date || opts.Inodes
+}
+
+// setInfo stores the stat result of n and the metadata columns asked for
+func (w *walker) setInfo(n *Node, info fs.FileInfo) {
+	n.info = info
+	w.setMeta(n, info)
+}
+
+// setMeta fills the metadata columns asked for from info, which is
+// that of the link itself for symlinks
+func (w *walker) setMeta(n *Node, info fs.FileInfo) {
+	if !w.opts.wantMeta() {
+		return
+	}
+	if w.opts.Perms {
+		n.Mode = info.Mode().String()
+	}
+	if w.opts.Date {
+		mtime := info.ModTime()
+		n.ModTime = &mtime
+	}
+	if w.opts.Owner || w.opts.Group || w.opts.Inodes {
+		owner, group, inode := fileOwner(info)
+		if w.opts.Owner {
+			n.Owner = names.user(owner)
+		}
+		if w.opts.Group {
+			n.Group = names.group(group)
+		}
+		if w.opts.Inodes {
+			n.Inode = inode
+		}
+	}
+}
+
+// fileOwner returns the owner and group, as ids or names, and the inode of an entry
+func fileOwner(info fs.FileInfo) (owner, group string, inode uint64) {
+	if hdr, ok := info.Sys().(*tar.Header); ok {
+		owner, group = hdr.Uname, hdr.Gname
+		if owner == "" {
+			owner = strconv.Itoa(hdr.Uid)
+		}
+		if group == "" {
+			group = strconv.Itoa(hdr.Gid)
+		}
+		return owner, group, 0
+	}
+	return sysOwner(info)
+}
+
+// nameCache resolves user and group ids to names, keeping the ids that are unknown
+type nameCache struct {
+	mu     sync.Mutex
+	users  map[string]string
+	groups map[string]string
+}
+
+var names = &nameCache{users: map[string]string{}, groups: map[string]string{}}
+
+func (c *nameCache) user(id string) string {
+	return c.lookup(c.users, id, func() (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+func (c *nameCache) group(id string) string {
+	return c.lookup(c.groups, id, func() (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+func (c *nameCache) lookup(cache map[string]string, id string, find func() (string, error)) string {
+	if id == "" || !isNumber(id) {
+		return id
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name, ok := cache[id]; ok {
+		return name
+	}
+	name, err := find()
+	if err != nil {
+		name = id
+	}
+	cache[id] = name
+	return name
+}
+
+func isNumber(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// metaColumns are the requested metadata of n in tree's order: inode, mode, owner, group, date
+func metaColumns(n *Node, opts Options) []string {
+	var cols []string
+	if opts.Inodes {
+		cols = append(cols, strconv.FormatUint(n.Inode, 10))
+	}
+	if opts.Perms {
+		cols = append(cols, n.Mode)
+	}
+	if opts.Owner {
+		cols = append(cols, n.Owner)
+	}
+	if opts.Group {
+		cols = append(cols, n.Group)
+	}
+	if opts.Date {
+		date := ""
+		if n.ModTime != nil {
+			layout := opts.TimeFormat
+			if layout == "" {
+				layout = DefaultTimeFormat
+			}
+			date = n.ModTime.Format(layout)
+		}
+		cols = append(cols, date)
+	}
+	return cols
+}
+
+// metaWidths finds the widest value of every column below root
+func metaWidths(root *Node, opts Options) []int {
+	var widths []int
+	collectFiles(root, func(n *Node) {
+		cols := metaColumns(n, opts)
+		if widths == nil {
+			widths = make([]int, len(cols))
+		}
+		for i, col := range cols {
+			if w := len([]rune(col)); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	})
+	return widths
+}
+
+// metaText is the bracketed metadata shown before the name, padded to widths if given;
+// numbers are aligned right and text left
+func metaText(n *Node, opts Options, widths []int) string {
+	cols := metaColumns(n, opts)
+	if len(cols) == 0 {
+		return ""
+	}
+	for i, col := range cols {
+		if i >= len(widths) {
+			break
+		}
+		pad := strings.Repeat(" ", widths[i]-len([]rune(col)))
+		if opts.Inodes && i == 0 {
+			cols[i] = pad + col
+		} else {
+			cols[i] = col + pad
+		}
+	}
+	return "[" + strings.Join(cols, " ") + "] "
+}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package dirtree
+
+import "io/fs"
+
+// sysOwner knows nothing about owners and inodes on this platform
+func sysOwner(info fs.FileInfo) (owner, group string, inode uint64) {
+	return "", "", 0
+}
@@ -0,0 +1,19 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package dirtree
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// sysOwner reads the owner and group ids and the inode from the stat result
+func sysOwner(info fs.FileInfo) (owner, group string, inode uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", "", 0
+	}
+	return strconv.FormatUint(uint64(st.Uid), 10), strconv.FormatUint(uint64(st.Gid), 10), uint64(st.Ino)
+}
@@ -0,0 +1,96 @@
+package dirtree
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// sniffLen is the number of leading bytes http.DetectContentType looks at
+const sniffLen = 512
+
+// sniffTypes sets the MIME type of every file from its leading bytes in parallel
+func (w *walker) sniffTypes(root *Node) {
+	var files []*Node
+	collectFiles(root, func(n *Node) {
+		if n.Type == typeFile && n.info != nil {
+			files = append(files, n)
+		}
+	})
+	errs := make([]error, len(files))
+	parallel(len(files), func(idx int) {
+		files[idx].MimeType, errs[idx] = sniffType(w.fsys, files[idx].fsPath)
+	})
+	for idx, err := range errs {
+		if err != nil {
+			w.fail(files[idx], "error reading", err)
+		}
+	}
+}
+
+// sniffType is the media type of the file name without parameters such as the charset
+func sniffType(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	mediaType := http.DetectContentType(buf[:n])
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	return mediaType, nil
+}
+
+// isText reports whether a sniffed media type is human readable text
+func isText(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+// typeStat is the number and total size of files of one media type
+type typeStat struct {
+	mediaType string
+	files     int
+	size      int64
+}
+
+// typeStats groups the files below root by media type, largest total first
+func typeStats(root *Node) []typeStat {
+	byType := map[string]*typeStat{}
+	collectFiles(root, func(n *Node) {
+		if n.MimeType == "" {
+			return
+		}
+		if byType[n.MimeType] == nil {
+			byType[n.MimeType] = &typeStat{mediaType: n.MimeType}
+		}
+		byType[n.MimeType].files++
+		byType[n.MimeType].size += n.Size
+	})
+	stats := make([]typeStat, 0, len(byType))
+	for _, s := range byType {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].size != stats[j].size {
+			return stats[i].size > stats[j].size
+		}
+		return stats[i].mediaType < stats[j].mediaType
+	})
+	return stats
+}
+
+func typesText(stats []typeStat, opts Options) string {
+	var sb strings.Builder
+	for _, s := range stats {
+		sb.WriteString(s.mediaType + ": " + plural(s.files, "file", "files") + ", " + summarySize(s.size, opts) + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,192 @@
+package dirtree
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	connectorMiddle = "├───"
+	connectorLast   = "└───"
+	indentOpen      = "│\t"
+	indentLast      = "\t"
+)
+
+// ParseError is a malformed line of a text tree
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return "line " + strconv.Itoa(e.Line) + ": " + e.Msg
+}
+
+// sizeSuffix is the "(Nb)" or "(empty)" annotation that marks a file
+var sizeSuffix = regexp.MustCompile(` \((empty|[0-9]+b)\)$`)
+
+// parseLevel is a directory whose children are being read
+type parseLevel struct {
+	dir *Node
+	// drawnLast is set when the directory itself was drawn with └───
+	drawnLast bool
+	// closed is set once its last child, drawn with └───, was read
+	closed bool
+	names  map[string]bool
+}
+
+// Parse reads the box-drawing text written by the Text formatter back into a tree.
+// Lines with a size annotation are files, all others are directories; the parser is
+// strict about the indentation and connectors, so only well formed trees are accepted
+func Parse(r io.Reader) (*Node, error) {
+	root := newNode(".", typeDir)
+	stack := []*parseLevel{{dir: root, names: map[string]bool{}}}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		fail := func(format string, args ...interface{}) error {
+			return &ParseError{Line: lineNo, Msg: fmt.Sprintf(format, args...)}
+		}
+		if line == "" {
+			return nil, fail("empty line")
+		}
+
+		// every indent unit must match how the directory at that level was drawn
+		rest := line
+		depth := 0
+		for {
+			var unit string
+			switch {
+			case strings.HasPrefix(rest, indentOpen):
+				unit = indentOpen
+			case strings.HasPrefix(rest, indentLast):
+				unit = indentLast
+			}
+			if unit == "" {
+				break
+			}
+			if depth+1 >= len(stack) {
+				return nil, fail("indented deeper than its parent")
+			}
+			if stack[depth+1].drawnLast != (unit == indentLast) {
+				return nil, fail("indent %q does not match the connector of %q", unit, stack[depth+1].dir.Name)
+			}
+			rest = rest[len(unit):]
+			depth++
+		}
+
+		var last bool
+		switch {
+		case strings.HasPrefix(rest, connectorMiddle):
+			rest = rest[len(connectorMiddle):]
+		case strings.HasPrefix(rest, connectorLast):
+			rest = rest[len(connectorLast):]
+			last = true
+		default:
+			return nil, fail("expected %q or %q", connectorMiddle, connectorLast)
+		}
+
+		// a shallower line ends the directories below it, each must have seen its last entry
+		for len(stack) > depth+1 {
+			if top := stack[len(stack)-1]; !top.closed && len(top.dir.Children) > 0 {
+				return nil, fail("%q has no entry drawn with %q", top.dir.Name, connectorLast)
+			}
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) < depth+1 {
+			return nil, fail("indented deeper than its parent")
+		}
+		level := stack[depth]
+		if level.closed {
+			return nil, fail("entry after the last entry of %q", level.dir.Name)
+		}
+
+		n, err := parseEntry(rest)
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+		if level.names[n.Name] {
+			return nil, fail("duplicate entry %q", n.Name)
+		}
+		level.names[n.Name] = true
+		level.dir.Children = append(level.dir.Children, n)
+		level.closed = last
+		if n.IsDir() {
+			stack = append(stack, &parseLevel{dir: n, drawnLast: last, names: map[string]bool{}})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, level := range stack {
+		if !level.closed && len(level.dir.Children) > 0 {
+			return nil, &ParseError{Line: lineNo, Msg: fmt.Sprintf("%q has no entry drawn with %q", level.dir.Name, connectorLast)}
+		}
+	}
+	return root, nil
+}
+
+// parseEntry reads a name and its optional size annotation
+func parseEntry(label string) (*Node, error) {
+	typ := typeDir
+	var size int64
+	if m := sizeSuffix.FindStringSubmatch(label); m != nil {
+		typ = typeFile
+		label = label[:len(label)-len(m[0])]
+		if m[1] != "empty" {
+			var err error
+			size, err = strconv.ParseInt(strings.TrimSuffix(m[1], "b"), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad size %q", m[1])
+			}
+		}
+	}
+	switch {
+	case label == "":
+		return nil, errors.New("empty name")
+	case label == "." || label == ".." || strings.ContainsAny(label, `/\`):
+		return nil, fmt.Errorf("invalid name %q", label)
+	}
+	n := newNode(label, typ)
+	n.Size = size
+	return n, nil
+}
+
+// Scaffold creates the directories and files of tree under dir; files get the
+// size they were annotated with and zero contents. Existing files are never overwritten
+func Scaffold(dir string, tree *Node) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, n := range tree.Children {
+		full := filepath.Join(dir, n.Name)
+		if n.IsDir() {
+			if err := Scaffold(full, n); err != nil {
+				return err
+			}
+			continue
+		}
+		f, err := os.OpenFile(full, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		err = f.Truncate(n.Size)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package dirtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler serves the tree of any directory below dir in fsys; the URL path names
+// the directory relative to dir. Pages are HTML unless the format query
+// parameter names another of Formats, so "/static?format=json" is the JSON of static.
+// Query parameters named after the command line flags override opts:
+// f, L, I and P (both repeatable), gitignore, prune, du, h, sort, reverse, dirsfirst and mime.
+// Symlinks are listed but never followed, whatever opts.FollowLinks says,
+// and paths leaving dir, also through symlinks, are rejected.
+func Handler(fsys fs.FS, dir string, opts Options) http.Handler {
+	return handler(fsys, dir, path.Base(dir), opts)
+}
+
+// handler is Handler with the name shown for dir itself
+func handler(fsys fs.FS, dir, rootName string, opts Options) http.Handler {
+	// a followed link could list directories outside dir
+	opts.FollowLinks = false
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rel := strings.Trim(r.URL.Path, "/")
+		if rel == "" {
+			rel = "."
+		}
+		if !fs.ValidPath(rel) {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+		name := path.Join(dir, rel)
+		if err := checkServedPath(fsys, dir, rel); err != nil {
+			serveError(w, err)
+			return
+		}
+
+		reqOpts, err := queryOptions(r, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		label := rootName
+		if rel != "." {
+			label = path.Base(rel)
+		}
+		root, err := buildTree(fsys, name, label, reqOpts)
+		if root == nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// errors of single entries are shown on their nodes
+		buf := new(bytes.Buffer)
+		if err := render(buf, root, reqOpts); err != nil {
+			serveError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypes[r.URL.Query().Get("format")])
+		w.Write(buf.Bytes())
+	})
+}
+
+// checkServedPath makes sure rel below dir is a directory that is not reached through a symlink
+func checkServedPath(fsys fs.FS, dir, rel string) error {
+	if lfs, ok := fsys.(linkFS); ok && rel != "." {
+		name := dir
+		for _, part := range strings.Split(rel, "/") {
+			name = path.Join(name, part)
+			if _, err := lfs.ReadLink(name); err == nil {
+				return fs.ErrPermission
+			}
+		}
+	}
+	info, err := fs.Stat(fsys, path.Join(dir, rel))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// contentTypes maps the format query parameter to the response type
+var contentTypes = map[string]string{
+	"":     "text/html; charset=utf-8",
+	"html": "text/html; charset=utf-8",
+	"text": "text/plain; charset=utf-8",
+	"json": "application/json",
+	"xml":  "application/xml",
+}
+
+func serveError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "not found", http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// queryOptions applies the query parameters of r on top of opts
+func queryOptions(r *http.Request, opts Options) (Options, error) {
+	query := r.URL.Query()
+	opts.Formatter = HTML
+	if format := query.Get("format"); format != "" {
+		formatter, ok := Formats[format]
+		if !ok {
+			return opts, fmt.Errorf("unknown format %q", format)
+		}
+		opts.Formatter = formatter
+	}
+	bools := map[string]*bool{
+		"f":         &opts.Files,
+		"gitignore": &opts.Gitignore,
+		"prune":     &opts.Prune,
+		"du":        &opts.Du,
+		"h":         &opts.Human,
+		"reverse":   &opts.Reverse,
+		"dirsfirst": &opts.DirsFirst,
+		"mime":      &opts.Mime,
+	}
+	for key, dst := range bools {
+		if !query.Has(key) {
+			continue
+		}
+		value := query.Get(key)
+		if value == "" {
+			*dst = true
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("bad value %q for %s", value, key)
+		}
+		*dst = b
+	}
+	if query.Has("L") {
+		depth, err := strconv.Atoi(query.Get("L"))
+		if err != nil || depth < 0 {
+			return opts, fmt.Errorf("bad value %q for L", query.Get("L"))
+		}
+		opts.Depth = depth
+	}
+	if query.Has("sort") {
+		opts.Sort = query.Get("sort")
+	}
+	// patterns add to the ones the server was started with
+	opts.Exclude = append(opts.Exclude[:len(opts.Exclude):len(opts.Exclude)], query["I"]...)
+	opts.Include = append(opts.Include[:len(opts.Include):len(opts.Include)], query["P"]...)
+	return opts, nil
+}
+
+// Serve serves the tree of the directory at an OS path on addr until ctx is done
+func Serve(ctx context.Context, addr, dir string, opts Options) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler(newOSFS(dir), ".", filepath.Base(dir), opts),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,109 @@
+package dirtree
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// sorters compare two entries for the --sort flag, each returns true if a goes before b
+var sorters = map[string]func(a, b *Node) bool{
+	"name":    byName,
+	"size":    bySize,
+	"mtime":   byMtime,
+	"ext":     byExt,
+	"version": byVersion,
+}
+
+func byName(a, b *Node) bool {
+	return a.Name < b.Name
+}
+
+// bySize puts the largest entries first, using subtree totals for directories
+func bySize(a, b *Node) bool {
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	return byName(a, b)
+}
+
+// byMtime puts the most recently modified entries first
+func byMtime(a, b *Node) bool {
+	if a.info != nil && b.info != nil && !a.info.ModTime().Equal(b.info.ModTime()) {
+		return a.info.ModTime().After(b.info.ModTime())
+	}
+	return byName(a, b)
+}
+
+func byExt(a, b *Node) bool {
+	extA, extB := path.Ext(a.Name), path.Ext(b.Name)
+	if extA != extB {
+		return extA < extB
+	}
+	return byName(a, b)
+}
+
+// byVersion compares names so that digit runs are ordered by their numeric value,
+// "file2" goes before "file10"
+func byVersion(a, b *Node) bool {
+	if c := compareVersions(a.Name, b.Name); c != 0 {
+		return c < 0
+	}
+	return byName(a, b)
+}
+
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		chunkA, restA := nextChunk(a)
+		chunkB, restB := nextChunk(b)
+		if isDigit(chunkA[0]) && isDigit(chunkB[0]) {
+			numA, numB := strings.TrimLeft(chunkA, "0"), strings.TrimLeft(chunkB, "0")
+			if len(numA) != len(numB) {
+				return len(numA) - len(numB)
+			}
+			if c := strings.Compare(numA, numB); c != 0 {
+				return c
+			}
+		} else if c := strings.Compare(chunkA, chunkB); c != 0 {
+			return c
+		}
+		a, b = restA, restB
+	}
+	return len(a) - len(b)
+}
+
+// nextChunk splits s after its leading run of digits or non-digits
+func nextChunk(s string) (string, string) {
+	digits := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// sortNodes orders every level of the tree; --dirsfirst groups directories
+// ahead of files and is not affected by --reverse
+func sortNodes(nodes []*Node, opts Options) {
+	less, ok := sorters[opts.Sort]
+	if !ok {
+		less = byName
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if opts.DirsFirst && a.IsDir() != b.IsDir() {
+			return a.IsDir()
+		}
+		if opts.Reverse {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	for _, n := range nodes {
+		sortNodes(n.Children, opts)
+	}
+}
@@ -0,0 +1,449 @@
+package dirtree
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	typeDir  = "directory"
+	typeFile = "file"
+	typeLink = "link"
+)
+
+// Node is a single entry of the walked tree; for directories Size, Files, Links
+// and Dirs are totals of the whole subtree, symlinks are counted as Links only
+type Node struct {
+	XMLName xml.Name `json:"-"`
+	Name    string   `json:"name" xml:"name,attr"`
+	Type    string   `json:"type" xml:"-"`
+	Size    int64    `json:"size" xml:"size,attr"`
+	Files   int      `json:"files,omitempty" xml:"files,attr,omitempty"`
+	Links   int      `json:"links,omitempty" xml:"links,attr,omitempty"`
+	Dirs    int      `json:"dirs,omitempty" xml:"dirs,attr,omitempty"`
+
+	// Target is set on symlinks, which are directories when followed and links otherwise
+	Target    string `json:"target,omitempty" xml:"target,attr,omitempty"`
+	Dangling  bool   `json:"dangling,omitempty" xml:"dangling,attr,omitempty"`
+	Recursive bool   `json:"recursive,omitempty" xml:"recursive,attr,omitempty"`
+
+	// Status and OldSize are set in diff mode
+	Status  string `json:"status,omitempty" xml:"status,attr,omitempty"`
+	OldSize int64  `json:"old_size,omitempty" xml:"old_size,attr,omitempty"`
+
+	// metadata columns, filled only when asked for
+	Mode    string     `json:"mode,omitempty" xml:"mode,attr,omitempty"`
+	Owner   string     `json:"owner,omitempty" xml:"owner,attr,omitempty"`
+	Group   string     `json:"group,omitempty" xml:"group,attr,omitempty"`
+	ModTime *time.Time `json:"mtime,omitempty" xml:"mtime,attr,omitempty"`
+	Inode   uint64     `json:"inode,omitempty" xml:"inode,attr,omitempty"`
+
+	// MimeType is the media type sniffed from the first bytes of a file
+	MimeType string `json:"mime,omitempty" xml:"mime,attr,omitempty"`
+
+	// Language and the line counts are set in --loc mode, directories hold the totals of their subtree
+	Language string `json:"language,omitempty" xml:"language,attr,omitempty"`
+	Lines    int    `json:"lines,omitempty" xml:"lines,attr,omitempty"`
+	Blank    int    `json:"blank,omitempty" xml:"blank,attr,omitempty"`
+	Comments int    `json:"comments,omitempty" xml:"comments,attr,omitempty"`
+
+	// DupeGroup numbers the group of identical files this one belongs to
+	DupeGroup int `json:"dupe_group,omitempty" xml:"dupe_group,attr,omitempty"`
+
+	// Err describes why the entry could not be fully read
+	Err string `json:"error,omitempty" xml:"error,attr,omitempty"`
+
+	Children []*Node `json:"children,omitempty" xml:",omitempty"`
+
+	info fs.FileInfo
+	// fsPath is the name of the entry in the walked file system
+	fsPath string
+	// truncated is set on directories that were not descended into because of the depth limit
+	truncated bool
+	// targetDir is set on links pointing to a directory
+	targetDir bool
+	// dupes is set on the root in --dupes mode
+	dupes []dupeGroup
+	// types is set on the root when media types are sniffed
+	types []typeStat
+	// langs is set on the root in --loc mode
+	langs []langStat
+}
+
+func newNode(name, typ string) *Node {
+	return &Node{XMLName: xml.Name{Local: typ}, Name: name, Type: typ}
+}
+
+// newEntry makes a node for the entry at rel below the walk root
+func (w *walker) newEntry(rel, typ string) *Node {
+	n := newNode(path.Base(rel), typ)
+	n.fsPath = w.name(rel)
+	return n
+}
+
+func (n *Node) IsDir() bool {
+	return n.Type == typeDir
+}
+
+// Info is the stat result of the entry, nil if it could not be read
+func (n *Node) Info() fs.FileInfo {
+	return n.info
+}
+
+// Path is the name of the entry in the walked file system
+func (n *Node) Path() string {
+	return n.fsPath
+}
+
+func (n *Node) setType(typ string) {
+	n.Type = typ
+	n.XMLName.Local = typ
+}
+
+// walker holds the state shared by the whole walk of the directory root in fsys
+type walker struct {
+	fsys    fs.FS
+	root    string
+	opts    Options
+	exclude *ignoreList
+	include *ignoreList
+	// sem holds a token for every directory read in its own goroutine
+	sem chan struct{}
+
+	mu   sync.Mutex
+	errs WalkErrors
+}
+
+// fail records err on n, to be shown inline and returned from the walk
+func (w *walker) fail(n *Node, what string, err error) {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	n.Err = what + ": " + err.Error()
+	w.mu.Lock()
+	w.errs = append(w.errs, fmt.Errorf("%s: %s: %w", w.opts.errPath(n.fsPath), what, err))
+	w.mu.Unlock()
+}
+
+// spawn runs fn in a new goroutine if a worker is free and inline otherwise,
+// so a directory never waits for a worker held by one of its ancestors
+func (w *walker) spawn(wg *sync.WaitGroup, fn func()) {
+	select {
+	case w.sem <- struct{}{}:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-w.sem }()
+			fn()
+		}()
+	default:
+		fn()
+	}
+}
+
+// WalkErrors collects every error met during a walk
+type WalkErrors []error
+
+func (e WalkErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e WalkErrors) Unwrap() []error {
+	return e
+}
+
+// name converts a path relative to the walk root into a name in fsys
+func (w *walker) name(rel string) string {
+	return path.Join(w.root, rel)
+}
+
+// visible reports whether the entry at rel passes the filters;
+// ignores are the .gitignore lists collected along the way
+func (w *walker) visible(rel string, isDir bool, ignores []*ignoreList) bool {
+	if w.opts.Gitignore && isDir && path.Base(rel) == ".git" {
+		return false
+	}
+	lists := append(ignores[:len(ignores):len(ignores)], w.exclude)
+	if isIgnored(lists, rel, isDir) {
+		return false
+	}
+	if !isDir && len(w.include.patterns) > 0 {
+		matched, _ := w.include.match(rel, false)
+		return matched
+	}
+	return true
+}
+
+// needFiles reports whether files must be walked even if they are not printed
+func (w *walker) needFiles() bool {
+	return w.opts.Files || w.opts.Prune || w.opts.Summary || w.opts.Dupes || w.opts.sniff() || w.opts.needTotals()
+}
+
+// recDir walks the directory at rel into dir, which is at the given depth below the root;
+// ancestors are the keys of the directories above it, used to detect symlink loops.
+// Subdirectories may be read concurrently, each fills only its own node
+// so the order of entries does not depend on scheduling
+func (w *walker) recDir(dir *Node, rel string, depth int, ignores []*ignoreList, ancestors []string) {
+	if w.opts.Gitignore {
+		if l := readIgnoreFile(w.fsys, w.name(path.Join(rel, ".gitignore")), rel); l != nil {
+			ignores = append(ignores[:len(ignores):len(ignores)], l)
+		}
+	}
+
+	var nodes []*Node
+	var wg sync.WaitGroup
+	// entries read before a failure are still listed
+	rawEntries, err := fs.ReadDir(w.fsys, w.name(rel))
+	if err != nil {
+		w.fail(dir, "error opening dir", err)
+	}
+	for _, entry := range rawEntries {
+		entryRel := path.Join(rel, entry.Name())
+
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if n := w.link(entry, entryRel, depth, ignores, ancestors); n != nil {
+				nodes = append(nodes, n)
+			}
+			continue
+		}
+
+		if !entry.IsDir() && !w.needFiles() {
+			continue
+		}
+		if !w.visible(entryRel, entry.IsDir(), ignores) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if entry.IsDir() {
+			n := w.newEntry(entryRel, typeDir)
+			if err == nil {
+				w.setInfo(n, info)
+			} else {
+				w.fail(n, "error reading info", err)
+			}
+			w.spawn(&wg, func() {
+				w.descend(n, entryRel, depth, ignores, ancestors)
+			})
+			nodes = append(nodes, n)
+			continue
+		}
+
+		n := w.newEntry(entryRel, typeFile)
+		if err == nil {
+			w.setInfo(n, info)
+			n.Size = info.Size()
+		} else {
+			w.fail(n, "error reading info", err)
+		}
+		nodes = append(nodes, n)
+	}
+	wg.Wait()
+	dir.Children = nodes
+}
+
+// descend fills the children of directory n unless the depth limit
+// or a loop back to one of its ancestors stops it
+func (w *walker) descend(n *Node, rel string, depth int, ignores []*ignoreList, ancestors []string) {
+	// totals need the whole subtree, it is cut to depth afterwards
+	if w.opts.Depth > 0 && depth >= w.opts.Depth && !w.opts.needTotals() {
+		n.truncated = true
+		return
+	}
+	key := dirKey(w.fsys, w.name(rel), n.info)
+	for _, ancestor := range ancestors {
+		if key != "" && key == ancestor {
+			n.Recursive = true
+			return
+		}
+	}
+	ancestors = append(ancestors[:len(ancestors):len(ancestors)], key)
+	w.recDir(n, rel, depth+1, ignores, ancestors)
+}
+
+// link makes a node for the symlink entry at rel, following it when it points
+// to a directory and -l is set; nil means the link is filtered out.
+// The target is only stated to know whether it is a directory,
+// the metadata columns describe the link itself
+func (w *walker) link(entry fs.DirEntry, rel string, depth int, ignores []*ignoreList, ancestors []string) *Node {
+	var target string
+	var linkErr error
+	if lfs, ok := w.fsys.(linkFS); ok {
+		target, linkErr = lfs.ReadLink(w.name(rel))
+	}
+	info, err := fs.Stat(w.fsys, w.name(rel))
+	isDir := err == nil && info.IsDir()
+	if !isDir && !w.needFiles() {
+		return nil
+	}
+	if !w.visible(rel, isDir, ignores) {
+		return nil
+	}
+
+	n := w.newEntry(rel, typeLink)
+	n.Target = target
+	n.targetDir = isDir
+	if linkInfo, err := entry.Info(); err == nil {
+		w.setMeta(n, linkInfo)
+	}
+	switch {
+	case linkErr != nil:
+		w.fail(n, "error reading link", linkErr)
+	case errors.Is(err, fs.ErrNotExist):
+		n.Dangling = true
+	case err != nil:
+		w.fail(n, "error reading link target", err)
+	default:
+		n.info = info
+	}
+	// following is only safe where directories can be identified for loop detection
+	if isDir && w.opts.FollowLinks && dirKey(w.fsys, w.name(rel), info) != "" {
+		n.setType(typeDir)
+		w.descend(n, rel, depth, ignores, ancestors)
+		if n.Recursive {
+			n.setType(typeLink)
+		}
+	}
+	return n
+}
+
+// pruneEmpty drops directories that ended up without files;
+// truncated and failed ones are kept since their contents are unknown
+func pruneEmpty(nodes []*Node) []*Node {
+	kept := nodes[:0]
+	for _, n := range nodes {
+		if n.IsDir() {
+			n.Children = pruneEmpty(n.Children)
+			if len(n.Children) == 0 && !n.truncated && n.Err == "" {
+				continue
+			}
+		}
+		kept = append(kept, n)
+	}
+	return kept
+}
+
+// dirsOnly drops files that were walked but should not be printed,
+// links to directories are kept
+func dirsOnly(nodes []*Node) []*Node {
+	kept := nodes[:0]
+	for _, n := range nodes {
+		if n.IsDir() {
+			n.Children = dirsOnly(n.Children)
+		}
+		if n.IsDir() || n.targetDir {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// summarize fills directory totals from the already walked children
+func summarize(n *Node) {
+	if !n.IsDir() {
+		return
+	}
+	n.Size, n.Files, n.Links, n.Dirs = 0, 0, 0, 0
+	for _, child := range n.Children {
+		summarize(child)
+		n.Files += child.Files
+		n.Links += child.Links
+		n.Dirs += child.Dirs
+		switch child.Type {
+		case typeDir:
+			n.Dirs++
+		case typeLink:
+			// the size of a link is that of its target, counted where the target is
+			n.Links++
+			continue
+		default:
+			n.Files++
+		}
+		n.Size += child.Size
+	}
+}
+
+// truncateDepth cuts directories deeper than maxDepth, keeping their totals
+func truncateDepth(nodes []*Node, depth, maxDepth int) {
+	for _, n := range nodes {
+		if !n.IsDir() {
+			continue
+		}
+		if depth >= maxDepth {
+			n.Children = nil
+			n.truncated = true
+			continue
+		}
+		truncateDepth(n.Children, depth+1, maxDepth)
+	}
+}
+
+// buildTree walks the directory dir of fsys and applies pruning, totals,
+// sorting and the depth limit; name is used for the root node.
+// Entries that failed are kept in the tree and also returned as WalkErrors
+func buildTree(fsys fs.FS, dir, name string, opts Options) (*Node, error) {
+	if opts.Sort == "" {
+		opts.Sort = "name"
+	}
+	if _, ok := sorters[opts.Sort]; !ok {
+		return nil, fmt.Errorf("unknown sort mode %q", opts.Sort)
+	}
+	root := newNode(name, typeDir)
+	root.fsPath = dir
+	w := &walker{
+		fsys:    fsys,
+		root:    dir,
+		opts:    opts,
+		exclude: newIgnoreList("", opts.Exclude),
+		include: newIgnoreList("", opts.Include),
+	}
+	if opts.Workers > 1 {
+		w.sem = make(chan struct{}, opts.Workers)
+	}
+	var ancestors []string
+	if info, err := fs.Stat(fsys, dir); err == nil {
+		w.setInfo(root, info)
+		ancestors = append(ancestors, dirKey(fsys, dir, info))
+	}
+	w.recDir(root, "", 1, nil, ancestors)
+	if opts.Prune {
+		root.Children = pruneEmpty(root.Children)
+	}
+	summarize(root)
+	if opts.sniff() {
+		w.sniffTypes(root)
+		root.types = typeStats(root)
+	}
+	if opts.Loc {
+		w.countLines(root)
+		root.langs = langStats(root)
+	}
+	sortNodes(root.Children, opts)
+	if opts.needTotals() && opts.Depth > 0 {
+		truncateDepth(root.Children, 1, opts.Depth)
+	}
+	if opts.Dupes {
+		root.dupes = w.findDupes(root)
+	}
+	if !opts.Files {
+		root.Children = dirsOnly(root.Children)
+	}
+	if len(w.errs) > 0 {
+		// errors of a concurrent walk come in any order
+		sort.Slice(w.errs, func(i, j int) bool { return w.errs[i].Error() < w.errs[j].Error() })
+		return root, w.errs
+	}
+	return root, nil
+}
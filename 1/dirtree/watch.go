@@ -0,0 +1,183 @@
+package dirtree
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WatchOptions controls how Watch notices and reports changes
+type WatchOptions struct {
+	// Interval is the polling period, and with inotify the delay
+	// that lets a burst of events settle before the tree is walked again
+	Interval time.Duration
+	// Diff prints only the entries that changed since the previous render
+	// instead of clearing the screen and redrawing the whole tree
+	Diff bool
+	// Poll disables inotify and always polls
+	Poll bool
+}
+
+// DefaultWatchInterval is used when WatchOptions.Interval is not set
+const DefaultWatchInterval = time.Second
+
+const clearScreen = "\x1b[H\x1b[2J"
+
+// notifier reports possible changes under the directories it was given
+type notifier interface {
+	watch(dirs []string)
+	events() <-chan struct{}
+	close() error
+}
+
+// Watch renders the tree of dir and renders it again whenever entries are added,
+// removed or resized under it, until ctx is done. It uses inotify where available
+// and falls back to polling. Errors of single entries are shown inline and do not stop it.
+func Watch(ctx context.Context, out io.Writer, dir string, opts Options, wopts WatchOptions) error {
+	if wopts.Interval <= 0 {
+		wopts.Interval = DefaultWatchInterval
+	}
+	fsys := newOSFS(dir)
+	name := filepath.Base(dir)
+	opts.errRoot = dir
+
+	var n notifier
+	if !wopts.Poll {
+		if inotify, err := newNotifier(); err == nil {
+			n = inotify
+			defer n.close()
+		}
+	}
+
+	prev, err := buildTree(fsys, ".", name, opts)
+	if prev == nil {
+		return err
+	}
+	if !wopts.Diff {
+		io.WriteString(out, clearScreen)
+	}
+	if err := render(out, cloneTree(prev), opts); err != nil {
+		return err
+	}
+
+	for {
+		if n != nil {
+			n.watch(treeDirs(dir, prev))
+		}
+		if !waitChange(ctx, n, wopts.Interval) {
+			return nil
+		}
+
+		next, err := buildTree(fsys, ".", name, opts)
+		if next == nil {
+			return err
+		}
+		if treeSignature(next) == treeSignature(prev) {
+			continue
+		}
+
+		if wopts.Diff {
+			err = renderChanges(out, prev, next, opts)
+		} else {
+			io.WriteString(out, clearScreen)
+			err = render(out, cloneTree(next), opts)
+		}
+		if err != nil {
+			return err
+		}
+		prev = next
+	}
+}
+
+// waitChange blocks until a change may have happened, false means ctx is done
+func waitChange(ctx context.Context, n notifier, interval time.Duration) bool {
+	if n == nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(interval):
+			return true
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-n.events():
+	}
+	// let the rest of a burst arrive before walking again
+	settle := time.NewTimer(interval)
+	defer settle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-n.events():
+		case <-settle.C:
+			return true
+		}
+	}
+}
+
+// renderChanges prints a timestamped tree of what changed between prev and next
+func renderChanges(out io.Writer, prev, next *Node, opts Options) error {
+	root := cloneTree(next)
+	d := &differ{}
+	root.Children = changedOnly(d.merge(cloneTree(prev).Children, root.Children))
+	sortNodes(root.Children, opts)
+	opts.diff = true
+	if _, err := io.WriteString(out, "--- "+time.Now().Format("15:04:05")+"\n"); err != nil {
+		return err
+	}
+	return render(out, root, opts)
+}
+
+// changedOnly keeps the marked entries and the directories leading to them
+func changedOnly(nodes []*Node) []*Node {
+	var kept []*Node
+	for _, n := range nodes {
+		if n.Status != "" {
+			kept = append(kept, n)
+			continue
+		}
+		if n.IsDir() {
+			if n.Children = changedOnly(n.Children); len(n.Children) > 0 {
+				kept = append(kept, n)
+			}
+		}
+	}
+	return kept
+}
+
+// cloneTree copies the nodes of a tree so it can be merged without changing the original
+func cloneTree(n *Node) *Node {
+	c := *n
+	c.Children = make([]*Node, len(n.Children))
+	for i, child := range n.Children {
+		c.Children[i] = cloneTree(child)
+	}
+	return &c
+}
+
+// treeSignature lists every entry with what Watch reacts to: its type, size and target
+func treeSignature(root *Node) string {
+	var sb strings.Builder
+	collectFiles(root, func(n *Node) {
+		sb.WriteString(n.fsPath + "\x00" + n.Type + "\x00" + strconv.FormatInt(n.Size, 10) + "\x00" + n.Target + "\n")
+	})
+	return sb.String()
+}
+
+// treeDirs are the OS paths of the root and every directory walked below it
+func treeDirs(dir string, root *Node) []string {
+	dirs := []string{dir}
+	collectFiles(root, func(n *Node) {
+		if n.IsDir() {
+			dirs = append(dirs, filepath.Join(dir, filepath.FromSlash(n.fsPath)))
+		}
+	})
+	return dirs
+}
@@ -0,0 +1,61 @@
+package dirtree
+
+import (
+	"os"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotify reports a change for every batch of events read from the kernel;
+// the events themselves are not decoded since the tree is walked again anyway
+type inotify struct {
+	fd   int
+	file *os.File
+	ch   chan struct{}
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	n := &inotify{
+		fd: fd,
+		// a non-blocking descriptor goes through the runtime poller, so close unblocks read
+		file: os.NewFile(uintptr(fd), "inotify"),
+		ch:   make(chan struct{}, 1),
+	}
+	go n.read()
+	return n, nil
+}
+
+func (n *inotify) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if _, err := n.file.Read(buf); err != nil {
+			return
+		}
+		select {
+		case n.ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watch adds every directory again, so ones that were removed and created
+// anew are watched too; adding a watched directory only updates its mask
+func (n *inotify) watch(dirs []string) {
+	for _, dir := range dirs {
+		syscall.InotifyAddWatch(n.fd, dir, inotifyMask)
+	}
+}
+
+func (n *inotify) events() <-chan struct{} {
+	return n.ch
+}
+
+func (n *inotify) close() error {
+	return n.file.Close()
+}
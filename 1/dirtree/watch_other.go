@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package dirtree
+
+import "errors"
+
+// newNotifier has no inotify to use on this platform, Watch polls instead
+func newNotifier() (notifier, error) {
+	return nil, errors.New("inotify is not available")
+}
@@ -2,81 +2,208 @@ package main
 
 import (
 	"bytes"
-	"io/fs"
+	"context"
+	"flag"
+	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strconv"
+	"runtime"
+	"strings"
+
+	"hw/dirtree"
 )
 
 func main() {
-	var out bytes.Buffer
-	if !(len(os.Args) == 2 || len(os.Args) == 3) {
-		panic("usage go run main.go . [-f]")
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: go run main.go . [-f] [flags]\n")
+		flags.PrintDefaults()
 	}
-	path := os.Args[1]
-	printFiles := len(os.Args) == 3 && os.Args[2] == "-f"
-	err := dirTree(&out, path, printFiles)
-	if err != nil {
-		panic(err.Error())
+	var opts dirtree.Options
+	flags.BoolVar(&opts.Files, "f", false, "print files")
+	format := flags.String("format", "text", "output format: text, json, xml or html")
+	flags.Var((*patternsFlag)(&opts.Exclude), "I", "exclude entries matching a gitignore-style `pattern` (repeatable)")
+	flags.Var((*patternsFlag)(&opts.Exclude), "exclude", "same as -I")
+	flags.Var((*patternsFlag)(&opts.Include), "P", "list only files matching a gitignore-style `pattern` (repeatable)")
+	flags.Var((*patternsFlag)(&opts.Include), "include", "same as -P")
+	flags.BoolVar(&opts.Gitignore, "gitignore", false, "honor .gitignore files found along the walk and hide .git")
+	flags.IntVar(&opts.Depth, "L", 0, "descend at most `N` levels deep, 0 for no limit")
+	flags.BoolVar(&opts.Prune, "prune", false, "hide directories without matching files")
+	flags.BoolVar(&opts.Du, "du", false, "annotate directories with the size and file count of their subtree")
+	flags.BoolVar(&opts.Human, "h", false, "print sizes in human readable units (KiB, MiB, ...)")
+	flags.BoolVar(&opts.Summary, "summary", false, "print a directory, file and size total after the text tree")
+	flags.StringVar(&opts.Sort, "sort", "name", "sort entries by name, size (largest first), mtime (newest first), ext or version")
+	flags.BoolVar(&opts.Reverse, "reverse", false, "reverse the sort order")
+	flags.BoolVar(&opts.DirsFirst, "dirsfirst", false, "list directories before files")
+	flags.BoolVar(&opts.FollowLinks, "l", false, "follow symbolic links to directories")
+	diff := flags.Bool("diff", false, "compare two directories given as arguments, marking added (+), removed (-) and changed (~) entries")
+	flags.BoolVar(&opts.Hash, "hash", false, "in diff mode also compare file contents by SHA-256")
+	flags.BoolVar(&opts.Color, "color", false, "colorize diff output")
+	flags.BoolVar(&opts.Dupes, "dupes", false, "find files with identical contents and report the space they waste")
+	flags.BoolVar(&opts.Mime, "mime", false, "annotate files with the media type sniffed from their contents")
+	flags.BoolVar(&opts.Types, "types", false, "print the number of files and bytes per media type after the text tree")
+	flags.BoolVar(&opts.Loc, "loc", false, "count lines, comment and blank lines of text files per directory and language")
+	flags.BoolVar(&opts.Perms, "p", false, "print permissions")
+	flags.BoolVar(&opts.Owner, "u", false, "print owner names")
+	flags.BoolVar(&opts.Group, "g", false, "print group names")
+	flags.BoolVar(&opts.Date, "D", false, "print modification dates")
+	flags.BoolVar(&opts.Inodes, "inodes", false, "print inode numbers")
+	flags.StringVar(&opts.TimeFormat, "timefmt", dirtree.DefaultTimeFormat, "Go time `layout` for -D dates")
+	flags.IntVar(&opts.Workers, "workers", runtime.NumCPU(), "number of directories read concurrently")
+	watch := flags.Bool("watch", false, "keep running and redraw the tree whenever entries are added, removed or resized")
+	var wopts dirtree.WatchOptions
+	flags.BoolVar(&wopts.Diff, "watch-diff", false, "in watch mode print only what changed instead of redrawing")
+	flags.BoolVar(&wopts.Poll, "poll", false, "in watch mode poll instead of using inotify")
+	flags.DurationVar(&wopts.Interval, "interval", dirtree.DefaultWatchInterval, "watch mode polling period and inotify settle delay")
+	serve := flags.String("serve", "", "serve the tree over HTTP on `addr`, such as :8080, as HTML or as ?format=json")
+	manifest := flags.String("manifest", "", "write the path, size, mode and SHA-256 of every file, or target of every symlink, to a JSON `file`")
+	verify := flags.String("verify", "", "compare the tree with a JSON manifest `file` and report missing, extra and modified files")
+	scaffold := flags.String("scaffold", "", "create the tree read from stdin, or from the text file argument, under `dir`")
+	archive := flags.String("archive", "", "list a .zip, .tar or .tar.gz `file`, the path argument is then optional and taken inside the archive")
+
+	args := parseArgs(flags, os.Args[1:])
+	formatter, ok := dirtree.Formats[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		os.Exit(2)
 	}
-}
+	opts.Formatter = formatter
 
-func recDir(out *bytes.Buffer, path string, printFiles bool, prefix string) {
-	var entries []fs.DirEntry
-	rawEntries, _ := os.ReadDir(path)
-	if printFiles {
-		entries = rawEntries
-	} else {
-		for _, item := range rawEntries {
-			if item.IsDir() {
-				entries = append(entries, item)
-			}
+	var err error
+	switch {
+	case *watch && len(args) == 1:
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		err = dirtree.Watch(ctx, os.Stdout, args[0], opts, wopts)
+	case *serve != "" && len(args) == 1:
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		err = dirtree.Serve(ctx, *serve, args[0], opts)
+	case *diff && len(args) == 2:
+		err = dirtree.Diff(os.Stdout, args[0], args[1], opts)
+	case *manifest != "" && len(args) == 1:
+		err = writeManifest(*manifest, args[0], opts)
+	case *verify != "" && len(args) == 1:
+		err = verifyManifest(*verify, args[0], opts)
+	case *scaffold != "" && len(args) <= 1:
+		err = scaffoldTree(*scaffold, args)
+	case *archive != "" && len(args) <= 1:
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
 		}
+		err = dirtree.WriteArchive(os.Stdout, *archive, dir, opts)
+	case len(args) == 1:
+		err = dirtree.WriteDir(os.Stdout, args[0], opts)
+	default:
+		flags.Usage()
+		os.Exit(2)
 	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
 
-	for i, entry := range entries {
-		if !entry.IsDir() && !printFiles {
-			continue
+// parseArgs allows flags both before and after positional arguments,
+// so the old "main.go . -f" form keeps working
+func parseArgs(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
 		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
 
-		out.WriteString(prefix)
-
-		if i == len(entries)-1 {
-			out.WriteString("└───")
-		} else {
-			out.WriteString("├───")
-		}
+// writeManifest saves the manifest of dir to the file name; the tree is walked
+// before the file is created, and the file is left out when it lies inside dir
+func writeManifest(name, dir string, opts dirtree.Options) error {
+	opts.Exclude = append(opts.Exclude, manifestExclude(name, dir)...)
+	buf := new(bytes.Buffer)
+	err := dirtree.WriteManifest(buf, dir, opts)
+	if buf.Len() == 0 {
+		return err
+	}
+	if writeErr := os.WriteFile(name, buf.Bytes(), 0644); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
 
-		out.WriteString(entry.Name())
+// verifyManifest checks dir against the manifest saved in the file name
+func verifyManifest(name, dir string, opts dirtree.Options) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	opts.Exclude = append(opts.Exclude, manifestExclude(name, dir)...)
+	return dirtree.Verify(os.Stdout, dir, f, opts)
+}
 
-		if !entry.IsDir() {
-			info, err := entry.Info()
-			if err == nil {
-				out.WriteString(" (")
-				sizeText := "empty"
-				if info.Size() > 0 {
-					sizeText = strconv.FormatInt(info.Size(), 10)
-					sizeText += "b"
-				}
-				out.WriteString(sizeText)
-				out.WriteString(")")
-			}
+// manifestExclude returns a pattern matching the manifest file name
+// when it lies inside dir, so it never lists or checks itself
+func manifestExclude(name, dir string) []string {
+	absName, err := filepath.Abs(name)
+	if err != nil {
+		return nil
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	rel, err := filepath.Rel(absDir, absName)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	// anchored to the root, with glob characters escaped
+	var sb strings.Builder
+	sb.WriteString("/")
+	for _, c := range filepath.ToSlash(rel) {
+		if strings.ContainsRune(`\*?[ `, c) {
+			sb.WriteByte('\\')
 		}
+		sb.WriteRune(c)
+	}
+	return []string{sb.String()}
+}
 
-		out.WriteString("\n")
-		if entry.IsDir() {
-			newPrefix := prefix
-			if i == len(entries)-1 {
-				newPrefix += "\t"
-			} else {
-				newPrefix += "│\t"
-			}
-			recDir(out, filepath.Join(path, entry.Name()), printFiles, newPrefix)
+// scaffoldTree creates the tree text given as a file argument or on stdin under dir
+func scaffoldTree(dir string, args []string) error {
+	in := os.Stdin
+	if len(args) == 1 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
 		}
+		defer f.Close()
+		in = f
+	}
+	tree, err := dirtree.Parse(in)
+	if err != nil {
+		return err
 	}
+	return dirtree.Scaffold(dir, tree)
 }
 
-func dirTree(out *bytes.Buffer, path string, printFiles bool) error {
-	recDir(out, path, printFiles, "")
+// patternsFlag collects a repeatable command line flag
+type patternsFlag []string
+
+func (f *patternsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *patternsFlag) Set(value string) error {
+	*f = append(*f, value)
 	return nil
 }
+
+func dirTree(out *bytes.Buffer, path string, printFiles bool) error {
+	return dirtree.WriteDir(out, path, dirtree.Options{Files: printFiles})
+}
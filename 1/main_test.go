@@ -2,7 +2,12 @@ package main
 
 import (
 	"bytes"
+	"embed"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"hw/dirtree"
 )
 
 const testFullResult = `├───project
@@ -73,3 +78,50 @@ func TestTreeDir(t *testing.T) {
 		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testDirResult)
 	}
 }
+
+//go:embed testdata
+var testdataFS embed.FS
+
+func TestTreeEmbedFS(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := dirtree.Write(out, testdataFS, "testdata", dirtree.Options{Files: true}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if result := out.String(); result != testFullResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testFullResult)
+	}
+}
+
+func TestManifestInsideTree(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "out.json")
+	// the second run sees the manifest of the first one in the tree
+	for i := 0; i < 2; i++ {
+		if err := writeManifest(name, dir, dirtree.Options{}); err != nil {
+			t.Fatalf("cant write manifest: %v", err)
+		}
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("out.json")) {
+		t.Errorf("manifest lists itself:\n%s", data)
+	}
+	if err := verifyManifest(name, dir, dirtree.Options{}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+}
+
+func TestManifestMissingRoot(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out.json")
+	if err := writeManifest(name, filepath.Join(t.TempDir(), "missing"), dirtree.Options{}); err == nil {
+		t.Errorf("expected an error for a missing root")
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("no manifest must be written for a missing root, got %v", err)
+	}
+}
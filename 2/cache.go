@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+// Cache is a bounded LRU cache safe for concurrent use; concurrent misses
+// of the same key share a single call
+type Cache[K comparable, V any] struct {
+	size int
+
+	mu       sync.Mutex
+	lru      *list.List
+	items    map[K]*list.Element
+	inflight map[K]*cacheCall[V]
+	stats    CacheStats
+}
+
+// CacheStats counts lookups; Shared hits waited for a call already in flight
+type CacheStats struct {
+	Hits      int64
+	Shared    int64
+	Misses    int64
+	Evictions int64
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+type cacheCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// NewCache makes a cache holding at most size values, size below 1 means 1
+func NewCache[K comparable, V any](size int) *Cache[K, V] {
+	if size < 1 {
+		size = 1
+	}
+	return &Cache[K, V]{
+		size:     size,
+		lru:      list.New(),
+		items:    map[K]*list.Element{},
+		inflight: map[K]*cacheCall[V]{},
+	}
+}
+
+// Do returns the cached value of key or calls fn to compute it; errors are not cached
+// and a panic of fn is returned as an error. A caller waiting for another one's call
+// gives up when its own ctx is done, and calls again if the other caller was cancelled
+func (c *Cache[K, V]) Do(ctx context.Context, key K, fn func() (V, error)) (value V, err error) {
+	for {
+		c.mu.Lock()
+		if el, ok := c.items[key]; ok {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return el.Value.(*cacheEntry[K, V]).value, nil
+		}
+		call, ok := c.inflight[key]
+		if !ok {
+			break
+		}
+		c.stats.Shared++
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+		if isContextErr(call.err) && ctx.Err() == nil {
+			continue
+		}
+		return call.value, call.err
+	}
+	call := &cacheCall[V]{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = panicError(r)
+		}
+		c.mu.Lock()
+		delete(c.inflight, key)
+		if call.err == nil {
+			c.add(key, call.value)
+		}
+		c.mu.Unlock()
+		close(call.done)
+		value, err = call.value, call.err
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err
+}
+
+// isContextErr reports whether a shared call failed only because its caller gave up
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// add stores a value evicting the least recently used ones; c.mu must be held
+func (c *Cache[K, V]) add(key K, value V) {
+	c.items[key] = c.lru.PushFront(&cacheEntry[K, V]{key, value})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry[K, V]).key)
+		c.stats.Evictions++
+	}
+}
+
+// Len is the number of cached values
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Stats is a snapshot of the counters
+func (c *Cache[K, V]) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Memoize wraps fn with c, for use with ParallelMap and Guarded functions
+func Memoize[K comparable, V any](c *Cache[K, V], fn func(ctx context.Context, key K) (V, error)) func(ctx context.Context, key K) (V, error) {
+	return func(ctx context.Context, key K) (V, error) {
+		return c.Do(ctx, key, func() (V, error) {
+			return fn(ctx, key)
+		})
+	}
+}
@@ -1,3 +1,3 @@
 module hw
 
-go 1.17
\ No newline at end of file
+go 1.18
\ No newline at end of file
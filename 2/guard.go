@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Guard protects a rate limited or exclusive resource: at most maxConcurrent
+// callers hold it at once, acquisitions are at least minInterval apart and
+// waiting callers are served in the order they arrived
+type Guard struct {
+	maxConcurrent int
+	minInterval   time.Duration
+
+	mu        sync.Mutex
+	active    int
+	lastStart time.Time
+	queue     []*guardWaiter
+	// timer is pending while the head of the queue waits for minInterval
+	timer *time.Timer
+	stats GuardStats
+}
+
+// GuardStats describes how long callers waited for a Guard
+type GuardStats struct {
+	Acquired  int64
+	Cancelled int64
+	// Active and Waiting are the current number of holders and queued callers
+	Active    int
+	Waiting   int
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// AvgWait is the mean wait of the callers that acquired the guard
+func (s GuardStats) AvgWait() time.Duration {
+	if s.Acquired == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Acquired)
+}
+
+type guardWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewGuard makes a guard; maxConcurrent below 1 means 1, minInterval 0 means no rate limit
+func NewGuard(maxConcurrent int, minInterval time.Duration) *Guard {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &Guard{maxConcurrent: maxConcurrent, minInterval: minInterval}
+}
+
+// Acquire waits for the resource; every successful Acquire must be followed by Release
+func (g *Guard) Acquire(ctx context.Context) error {
+	start := time.Now()
+	g.mu.Lock()
+	w := &guardWaiter{ready: make(chan struct{})}
+	g.queue = append(g.queue, w)
+	g.dispatch()
+	g.mu.Unlock()
+
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if w.granted {
+			// granted while giving up, pass the turn on
+			g.active--
+			g.dispatch()
+		} else {
+			g.remove(w)
+		}
+		g.stats.Cancelled++
+		return ctx.Err()
+	}
+
+	wait := time.Since(start)
+	g.mu.Lock()
+	g.stats.Acquired++
+	g.stats.TotalWait += wait
+	if wait > g.stats.MaxWait {
+		g.stats.MaxWait = wait
+	}
+	g.mu.Unlock()
+	return nil
+}
+
+// Release frees the resource for the next waiting caller
+func (g *Guard) Release() {
+	g.mu.Lock()
+	g.active--
+	g.dispatch()
+	g.mu.Unlock()
+}
+
+// Do calls fn while holding the guard
+func (g *Guard) Do(ctx context.Context, fn func()) error {
+	if err := g.Acquire(ctx); err != nil {
+		return err
+	}
+	defer g.Release()
+	fn()
+	return nil
+}
+
+// Stats is a snapshot of the wait metrics
+func (g *Guard) Stats() GuardStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stats := g.stats
+	stats.Active = g.active
+	stats.Waiting = len(g.queue)
+	return stats
+}
+
+// dispatch grants the resource to waiters from the head of the queue
+// while limits allow; g.mu must be held
+func (g *Guard) dispatch() {
+	for len(g.queue) > 0 && g.active < g.maxConcurrent {
+		if g.minInterval > 0 && !g.lastStart.IsZero() {
+			if wait := g.minInterval - time.Since(g.lastStart); wait > 0 {
+				if g.timer == nil {
+					g.timer = time.AfterFunc(wait, func() {
+						g.mu.Lock()
+						g.timer = nil
+						g.dispatch()
+						g.mu.Unlock()
+					})
+				}
+				return
+			}
+		}
+		w := g.queue[0]
+		g.queue = g.queue[1:]
+		w.granted = true
+		g.active++
+		g.lastStart = time.Now()
+		close(w.ready)
+	}
+}
+
+// remove drops a waiter that gave up; g.mu must be held
+func (g *Guard) remove(w *guardWaiter) {
+	for i, queued := range g.queue {
+		if queued == w {
+			g.queue = append(g.queue[:i], g.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// Guarded wraps fn so that every call holds g, for use with ParallelMap
+func Guarded[In, Out any](g *Guard, fn func(In) Out) func(ctx context.Context, value In) (Out, error) {
+	return func(ctx context.Context, value In) (Out, error) {
+		var res Out
+		err := g.Do(ctx, func() {
+			res = fn(value)
+		})
+		return res, err
+	}
+}
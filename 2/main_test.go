@@ -5,10 +5,16 @@ import (
 	// "hash/crc32"
 	// "strconv"
 	// "fmt
+	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"hash/crc32"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -171,3 +177,643 @@ func TestSigner(t *testing.T) {
 	}
 
 }
+
+func waitGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Errorf("goroutines leaked: %d, expected %d", runtime.NumGoroutine(), before)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPipelineContextError(t *testing.T) {
+	before := runtime.NumGoroutine()
+	errBad := errors.New("bad value")
+	var collected uint32
+	jobs := []ContextJob{
+		// an endless source stops only because the pipeline is cancelled
+		func(ctx context.Context, in, out chan interface{}) error {
+			for i := 0; ; i++ {
+				if err := Send(ctx, out, i); err != nil {
+					return err
+				}
+			}
+		},
+		func(ctx context.Context, in, out chan interface{}) error {
+			for val := range in {
+				if val.(int) == 3 {
+					return errBad
+				}
+				if err := Send(ctx, out, val); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// ignores ctx and reads until its input is closed
+		WithContext(func(in, out chan interface{}) {
+			for range in {
+				atomic.AddUint32(&collected, 1)
+			}
+		}),
+	}
+	err := ExecutePipelineContext(context.Background(), jobs...)
+	if !errors.Is(err, errBad) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if collected != 3 {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", collected, 3)
+	}
+	waitGoroutines(t, before)
+}
+
+func TestPipelineContextCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := ExecutePipelineContext(ctx,
+		func(ctx context.Context, in, out chan interface{}) error {
+			for {
+				if err := Send(ctx, out, 1); err != nil {
+					return err
+				}
+			}
+		},
+		// stops reading, the source must not stay blocked
+		func(ctx context.Context, in, out chan interface{}) error {
+			<-in
+			<-ctx.Done()
+			return nil
+		},
+	)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	waitGoroutines(t, before)
+}
+
+func TestPipelineContextPanic(t *testing.T) {
+	err := ExecutePipelineContext(context.Background(),
+		WithContext(func(in, out chan interface{}) {
+			out <- "not an int"
+		}),
+		WithContext(SingleHash),
+	)
+	if err == nil || !strings.Contains(err.Error(), "stage 1: panic") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSignerStage(t *testing.T) {
+	testExpected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	var testResult string
+	err := ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for _, fibNum := range []int{0, 1} {
+				if err := Send(ctx, out, fibNum); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		ToContextJob(SignerStage),
+		ToContextJob(func(ctx context.Context, in <-chan string, out chan<- struct{}) error {
+			testResult = <-in
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if testExpected != testResult {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", testResult, testExpected)
+	}
+}
+
+func TestStageWrongType(t *testing.T) {
+	before := runtime.NumGoroutine()
+	err := ExecutePipelineContext(context.Background(),
+		WithContext(func(in, out chan interface{}) {
+			out <- 1
+			out <- "two"
+			out <- 3
+		}),
+		ToContextJob(Then(
+			Stage[int, int](func(ctx context.Context, in <-chan int, out chan<- int) error {
+				for value := range in {
+					if err := Emit(ctx, out, value*2); err != nil {
+						return err
+					}
+				}
+				return nil
+			}),
+			Stage[int, string](func(ctx context.Context, in <-chan int, out chan<- string) error {
+				for value := range in {
+					if err := Emit(ctx, out, strconv.Itoa(value)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}),
+		)),
+	)
+	if err == nil || err.Error() != "stage 1: unexpected input string: two" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	waitGoroutines(t, before)
+}
+
+func TestParallelMap(t *testing.T) {
+	for _, ordered := range []bool{true, false} {
+		var running, maxRunning int32
+		double := ParallelMap(3, func(ctx context.Context, value int) (int, error) {
+			now := atomic.AddInt32(&running, 1)
+			for {
+				prev := atomic.LoadInt32(&maxRunning)
+				if now <= prev || atomic.CompareAndSwapInt32(&maxRunning, prev, now) {
+					break
+				}
+			}
+			// later values finish first
+			time.Sleep(time.Duration(10-value) * 5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return value * 2, nil
+		}, ordered)
+
+		in := make(chan int)
+		out := make(chan int)
+		go func() {
+			for i := 0; i < 10; i++ {
+				in <- i
+			}
+			close(in)
+		}()
+		errc := make(chan error, 1)
+		go func() {
+			errc <- double(context.Background(), in, out)
+			close(out)
+		}()
+		var result []int
+		for value := range out {
+			result = append(result, value)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if maxRunning > 3 {
+			t.Errorf("too many values at once: %d", maxRunning)
+		}
+		sorted := append([]int(nil), result...)
+		sort.Ints(sorted)
+		expected := []int{0, 2, 4, 6, 8, 10, 12, 14, 16, 18}
+		if fmt.Sprint(sorted) != fmt.Sprint(expected) || ordered && fmt.Sprint(result) != fmt.Sprint(expected) {
+			t.Errorf("results not match, ordered %v\nGot: %v\nExpected: %v", ordered, result, expected)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	g := NewGuard(2, 20*time.Millisecond)
+	var (
+		running, maxRunning int32
+		mu                  sync.Mutex
+		starts              []time.Time
+		wg                  sync.WaitGroup
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := g.Do(context.Background(), func() {
+				mu.Lock()
+				starts = append(starts, time.Now())
+				if now := atomic.AddInt32(&running, 1); now > maxRunning {
+					maxRunning = now
+				}
+				mu.Unlock()
+				time.Sleep(50 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxRunning != 2 {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", maxRunning, 2)
+	}
+	for i := 1; i < len(starts); i++ {
+		// allow for timer granularity
+		if gap := starts[i].Sub(starts[i-1]); gap < 19*time.Millisecond {
+			t.Errorf("acquisitions %d and %d only %s apart", i-1, i, gap)
+		}
+	}
+	stats := g.Stats()
+	if stats.Acquired != 5 || stats.Active != 0 || stats.Waiting != 0 || stats.MaxWait < 50*time.Millisecond || stats.AvgWait() <= 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestGuardFIFO(t *testing.T) {
+	g := NewGuard(1, 0)
+	if err := g.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var order []int
+	var mu sync.Mutex
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 4; i++ {
+		i := i
+		waitCtx := context.Background()
+		if i == 2 {
+			waitCtx = ctx
+		}
+		go func() {
+			err := g.Do(waitCtx, func() {
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+			})
+			if i == 2 && !errors.Is(err, context.Canceled) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			done <- struct{}{}
+		}()
+		// queue the callers one after another
+		for g.Stats().Waiting != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	cancel()
+	<-done
+	g.Release()
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	if fmt.Sprint(order) != "[0 1 3]" {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", order, "[0 1 3]")
+	}
+	if stats := g.Stats(); stats.Cancelled != 1 || stats.Acquired != 4 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache[string, int](2)
+	release := make(chan struct{})
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := c.Do(context.Background(), "a", func() (int, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return 1, nil
+			})
+			if value != 1 || err != nil {
+				t.Errorf("unexpected result: %v, %v", value, err)
+			}
+		}()
+	}
+	// release the call once every other caller joined it
+	for c.Stats().Shared != 9 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", calls, 1)
+	}
+
+	value := func(v int) func() (int, error) {
+		return func() (int, error) { return v, nil }
+	}
+	c.Do(context.Background(), "b", value(2))
+	c.Do(context.Background(), "a", value(0)) // hit, a becomes the most recent
+	c.Do(context.Background(), "c", value(3)) // evicts b
+	if got, _ := c.Do(context.Background(), "a", value(0)); got != 1 {
+		t.Errorf("a should still be cached, got %v", got)
+	}
+	if got, _ := c.Do(context.Background(), "b", value(4)); got != 4 {
+		t.Errorf("b should have been evicted, got %v", got)
+	}
+	if _, err := c.Do(context.Background(), "d", func() (int, error) { return 0, errors.New("failed") }); err == nil {
+		t.Errorf("expected an error")
+	}
+	expected := CacheStats{Hits: 2, Shared: 9, Misses: 5, Evictions: 2}
+	if stats := c.Stats(); stats != expected || c.Len() != 2 {
+		t.Errorf("results not match\nGot: %+v, len %d\nExpected: %+v, len 2", stats, c.Len(), expected)
+	}
+}
+
+func TestSignerCache(t *testing.T) {
+	crc32Signer := DataSignerCrc32
+	defer func() { DataSignerCrc32 = crc32Signer }()
+	var crc32Counter uint32
+	DataSignerCrc32 = func(data string) string {
+		atomic.AddUint32(&crc32Counter, 1)
+		return crc32Signer(data)
+	}
+
+	signer := NewSigner(SignerOptions{CacheSize: 100})
+	testExpected := "4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
+	var testResult string
+	err := ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for _, fibNum := range []int{1, 1} {
+				if err := Send(ctx, out, fibNum); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		ToContextJob(signer.Stage()),
+		ToContextJob(func(ctx context.Context, in <-chan string, out chan<- struct{}) error {
+			testResult = <-in
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if testExpected != testResult {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", testResult, testExpected)
+	}
+	// 2 in SingleHash and 6 in MultiHash for the single distinct value
+	if crc32Counter != 8 {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", crc32Counter, 8)
+	}
+	if stats := signer.Md5Cache.Stats(); stats.Misses != 1 || stats.Hits+stats.Shared != 1 {
+		t.Errorf("unexpected md5 cache stats: %+v", stats)
+	}
+}
+
+func TestPipelineMetrics(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		reports     [][]StageStats
+		sawExpvar   bool
+		maxQueuedAt time.Duration
+	)
+	p := &Pipeline{Name: "metrics-test", Interval: 10 * time.Millisecond}
+	p.OnStats = func(stats []StageStats) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, stats)
+		if stats[1].QueuedFor > maxQueuedAt {
+			maxQueuedAt = stats[1].QueuedFor
+		}
+		if expvarPipelines.Get("metrics-test#1") != nil {
+			sawExpvar = true
+		}
+	}
+	err := p.Run(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for i := 0; i < 5; i++ {
+				if err := Send(ctx, out, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// stalls before reading, then takes 5ms per value
+		func(ctx context.Context, in, out chan interface{}) error {
+			time.Sleep(100 * time.Millisecond)
+			for value := range in {
+				time.Sleep(5 * time.Millisecond)
+				if err := Send(ctx, out, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		func(ctx context.Context, in, out chan interface{}) error {
+			for range in {
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) < 2 || !sawExpvar {
+		t.Fatalf("expected periodic reports and an expvar entry, got %d reports", len(reports))
+	}
+	if maxQueuedAt < 50*time.Millisecond {
+		t.Errorf("the stalled stage should have shown a queued value, waited at most %s", maxQueuedAt)
+	}
+	final := reports[len(reports)-1]
+	for i, s := range final {
+		expectedIn := int64(5)
+		if i == 0 {
+			expectedIn = 0
+		}
+		if s.Running || s.In != expectedIn || s.Out != map[int]int64{0: 5, 1: 5, 2: 0}[i] || s.Queued != 0 {
+			t.Errorf("unexpected final stats: %v", s)
+		}
+	}
+	if s := final[1]; s.P50 < 5*time.Millisecond || s.P99 < s.P50 || s.InFlight != 0 {
+		t.Errorf("unexpected latencies: %v", s)
+	}
+	if expvarPipelines.Get("metrics-test#1") != nil {
+		t.Errorf("expvar entry should be removed after the run")
+	}
+	if stats := p.Stats(); len(stats) != 3 || stats[2].In != 5 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
+
+func TestExecutePipelinePanicStack(t *testing.T) {
+	defer func() {
+		err, ok := recover().(error)
+		// the stack names the job that panicked, not only the pipeline
+		if !ok || !strings.Contains(err.Error(), "stage 0: panic: bad value") || !strings.Contains(err.Error(), "panickingJob") {
+			t.Errorf("unexpected panic: %v", err)
+		}
+	}()
+	ExecutePipeline(panickingJob)
+	t.Errorf("ExecutePipeline should have panicked")
+}
+
+func panickingJob(in, out chan interface{}) {
+	panic("bad value")
+}
+
+func TestThenPanic(t *testing.T) {
+	before := runtime.NumGoroutine()
+	stage := Then(
+		Stage[int, int](func(ctx context.Context, in <-chan int, out chan<- int) error {
+			panic("first failed")
+		}),
+		Stage[int, int](func(ctx context.Context, in <-chan int, out chan<- int) error {
+			for range in {
+			}
+			return nil
+		}),
+	)
+	in := make(chan int)
+	close(in)
+	err := stage(context.Background(), in, make(chan int))
+	if err == nil || !strings.HasPrefix(err.Error(), "panic: first failed") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	waitGoroutines(t, before)
+}
+
+func TestToContextJobPanic(t *testing.T) {
+	before := runtime.NumGoroutine()
+	err := ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			return Send(ctx, out, 1)
+		},
+		ToContextJob(Stage[int, int](func(ctx context.Context, in <-chan int, out chan<- int) error {
+			<-in
+			panic("typed failed")
+		})),
+	)
+	if err == nil || !strings.HasPrefix(err.Error(), "stage 1: panic: typed failed") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	waitGoroutines(t, before)
+}
+
+func TestParallelMapPanic(t *testing.T) {
+	stage := ParallelMap(2, func(ctx context.Context, value int) (int, error) {
+		if value == 2 {
+			panic("bad value")
+		}
+		return value, nil
+	}, true)
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 5; i++ {
+			select {
+			case in <- i:
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+	out := make(chan int)
+	go func() {
+		for range out {
+		}
+	}()
+	err := stage(context.Background(), in, out)
+	close(out)
+	if err == nil || !strings.HasPrefix(err.Error(), "panic: bad value") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSignerCrc32Panic(t *testing.T) {
+	crc32Signer := DataSignerCrc32
+	defer func() { DataSignerCrc32 = crc32Signer }()
+	DataSignerCrc32 = func(data string) string {
+		panic("crc32 failed")
+	}
+	_, err := NewSigner(SignerOptions{}).multiHash(context.Background(), "1")
+	if err == nil || !strings.HasPrefix(err.Error(), "panic: crc32 failed") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCachePanic(t *testing.T) {
+	c := NewCache[string, int](2)
+	_, err := c.Do(context.Background(), "a", func() (int, error) {
+		panic("failed")
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "panic: failed") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if value, err := c.Do(ctx, "a", func() (int, error) { return 1, nil }); value != 1 || err != nil {
+		t.Errorf("unexpected result after a panic: %v, %v", value, err)
+	}
+}
+
+func TestCacheLeaderCancelled(t *testing.T) {
+	c := NewCache[string, int](2)
+	lookup := Memoize(c, func(ctx context.Context, key string) (int, error) {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return 1, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	})
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := lookup(leaderCtx, "a")
+		leaderErr <- err
+	}()
+	for c.Stats().Misses != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	waiter := make(chan error, 1)
+	go func() {
+		value, err := lookup(context.Background(), "a")
+		if value != 1 {
+			t.Errorf("results not match\nGot: %v\nExpected: %v", value, 1)
+		}
+		waiter <- err
+	}()
+	for c.Stats().Shared != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancelLeader()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected leader error: %v", err)
+	}
+	// the waiter's own ctx is live, it computes the value itself
+	if err := <-waiter; err != nil {
+		t.Errorf("unexpected waiter error: %v", err)
+	}
+}
+
+func TestPipelineMetricsLatencyDropped(t *testing.T) {
+	p := &Pipeline{}
+	err := p.Run(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for i := 0; i <= maxPendingInputs; i++ {
+				if err := Send(ctx, out, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// emits nothing before its input is closed, too many inputs to pair
+		func(ctx context.Context, in, out chan interface{}) error {
+			var values []interface{}
+			for value := range in {
+				values = append(values, value)
+			}
+			for _, value := range values {
+				if err := Send(ctx, out, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	s := p.Stats()[1]
+	if !s.LatencyDropped || s.P50 != 0 || s.P99 != 0 || s.Out != maxPendingInputs+1 {
+		t.Errorf("latencies should be dropped instead of misattributed: %v", s)
+	}
+}
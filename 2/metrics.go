@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencySamples is the number of recent latencies percentiles are computed from
+	latencySamples = 1024
+	// maxPendingInputs bounds the arrival times kept to match inputs with outputs
+	maxPendingInputs = 4096
+)
+
+// StageStats is a snapshot of the metrics of one pipeline stage
+type StageStats struct {
+	Stage   int
+	Running bool
+	// In and Out count the values the stage read and emitted,
+	// InFlight is their difference as seen for one to one stages
+	In       int64
+	Out      int64
+	InFlight int64
+	// Queued is 1 while a value emitted by the previous stage waits to be read by this one,
+	// the channels are unbuffered so no more than one ever waits; QueuedFor is how long
+	// it has been waiting, a stuck stage shows here
+	Queued    int
+	QueuedFor time.Duration
+	// latency percentiles from the n-th input to the n-th output of the stage; values are
+	// not tracked through the stage, so they only mean something for stages emitting one
+	// output per input in order. Once more than maxPendingInputs inputs wait for their
+	// output the pairing is lost, LatencyDropped is set and no more latencies are recorded
+	P50            time.Duration
+	P90            time.Duration
+	P99            time.Duration
+	LatencyDropped bool
+}
+
+func (s StageStats) String() string {
+	state := "done"
+	if s.Running {
+		state = "running"
+	}
+	dropped := ""
+	if s.LatencyDropped {
+		dropped = " (latency dropped)"
+	}
+	return "stage " + strconv.Itoa(s.Stage) + " " + state +
+		": in " + strconv.FormatInt(s.In, 10) + ", out " + strconv.FormatInt(s.Out, 10) +
+		", in flight " + strconv.FormatInt(s.InFlight, 10) +
+		", queued " + strconv.Itoa(s.Queued) + " for " + s.QueuedFor.String() +
+		", p50 " + s.P50.String() + ", p90 " + s.P90.String() + ", p99 " + s.P99.String() + dropped
+}
+
+// stageMetrics is updated by the forwarders around a stage while it runs
+type stageMetrics struct {
+	stage   int
+	running int32
+	in      int64
+	out     int64
+
+	mu          sync.Mutex
+	queuedSince time.Time
+	arrivals    []time.Time
+	latencies   [latencySamples]time.Duration
+	samples     int
+	// dropped stops latency sampling once arrivals overflowed
+	dropped bool
+}
+
+// queue marks a value waiting to be read by the stage
+func (m *stageMetrics) queue() {
+	m.mu.Lock()
+	m.queuedSince = time.Now()
+	m.mu.Unlock()
+}
+
+// received is called once the stage read a value
+func (m *stageMetrics) received() {
+	atomic.AddInt64(&m.in, 1)
+	m.mu.Lock()
+	m.queuedSince = time.Time{}
+	switch {
+	case m.dropped:
+	case len(m.arrivals) < maxPendingInputs:
+		m.arrivals = append(m.arrivals, time.Now())
+	default:
+		// later outputs could no longer be matched with their inputs
+		m.dropped = true
+		m.arrivals = nil
+	}
+	m.mu.Unlock()
+}
+
+// emitted is called for every value the stage sends
+func (m *stageMetrics) emitted() {
+	atomic.AddInt64(&m.out, 1)
+	m.mu.Lock()
+	if len(m.arrivals) > 0 {
+		m.latencies[m.samples%latencySamples] = time.Since(m.arrivals[0])
+		m.samples++
+		m.arrivals = m.arrivals[1:]
+	}
+	m.mu.Unlock()
+}
+
+func (m *stageMetrics) stats() StageStats {
+	s := StageStats{
+		Stage:   m.stage,
+		Running: atomic.LoadInt32(&m.running) == 1,
+		In:      atomic.LoadInt64(&m.in),
+		Out:     atomic.LoadInt64(&m.out),
+	}
+	if s.In > s.Out {
+		s.InFlight = s.In - s.Out
+	}
+	m.mu.Lock()
+	if !m.queuedSince.IsZero() {
+		s.Queued = 1
+		s.QueuedFor = time.Since(m.queuedSince)
+	}
+	s.LatencyDropped = m.dropped
+	n := m.samples
+	if n > latencySamples {
+		n = latencySamples
+	}
+	latencies := append([]time.Duration(nil), m.latencies[:n]...)
+	m.mu.Unlock()
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		percentile := func(p int) time.Duration {
+			return latencies[(len(latencies)-1)*p/100]
+		}
+		s.P50, s.P90, s.P99 = percentile(50), percentile(90), percentile(99)
+	}
+	return s
+}
+
+// Pipeline runs jobs connected by channels and collects per stage metrics.
+// The zero value is ready to use
+type Pipeline struct {
+	// Name publishes the metrics of every run in the expvar map "pipelines",
+	// served on /debug/vars by the default HTTP mux, under "Name#run"
+	Name string
+	// OnStats is called with the metrics of all stages every Interval,
+	// once per second if 0, and once more when the run ends
+	OnStats  func([]StageStats)
+	Interval time.Duration
+
+	mu      sync.Mutex
+	runs    int
+	current []*stageMetrics
+}
+
+// DefaultPipeline runs ExecutePipeline and ExecutePipelineContext
+var DefaultPipeline = &Pipeline{}
+
+// expvarPipelines holds the metrics of the named pipelines that are running
+var expvarPipelines = expvar.NewMap("pipelines")
+
+// Stats is a snapshot of the metrics of the latest run
+func (p *Pipeline) Stats() []StageStats {
+	p.mu.Lock()
+	metrics := p.current
+	p.mu.Unlock()
+	return snapshot(metrics)
+}
+
+func snapshot(metrics []*stageMetrics) []StageStats {
+	stats := make([]StageStats, len(metrics))
+	for i, m := range metrics {
+		stats[i] = m.stats()
+	}
+	return stats
+}
+
+// Run runs jobs connected by channels and waits for all of them.
+// The first error, or panic, of any job cancels the context of every stage and
+// is returned; if ctx itself is cancelled its error is returned.
+// Each stage drains its input after its job returns, so a stage that stopped
+// early never leaves the ones before it blocked on a send.
+func (p *Pipeline) Run(ctx context.Context, jobs ...ContextJob) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := &firstError{cancel: cancel}
+
+	metrics := make([]*stageMetrics, len(jobs))
+	for i := range metrics {
+		metrics[i] = &stageMetrics{stage: i, running: 1}
+	}
+	p.mu.Lock()
+	p.runs++
+	run := p.runs
+	p.current = metrics
+	p.mu.Unlock()
+
+	if p.Name != "" {
+		key := p.Name + "#" + strconv.Itoa(run)
+		expvarPipelines.Set(key, expvar.Func(func() interface{} {
+			return snapshot(metrics)
+		}))
+		defer expvarPipelines.Delete(key)
+	}
+	if p.OnStats != nil {
+		stop := p.report(metrics)
+		defer stop()
+	}
+
+	var wg sync.WaitGroup
+	in := make(chan interface{})
+	close(in)
+	for i, job := range jobs {
+		// the stage writes to emitted, forwarded feeds the next stage
+		emitted := make(chan interface{})
+		forwarded := make(chan interface{})
+		wg.Add(2)
+		go func(i int, job ContextJob, in, out chan interface{}) {
+			defer wg.Done()
+			errs.set(wrapStageErr(i, runJob(ctx, job, in, out)))
+			atomic.StoreInt32(&metrics[i].running, 0)
+			close(out)
+			for range in {
+			}
+		}(i, job, in, emitted)
+		go func(i int, emitted, forwarded chan interface{}) {
+			defer wg.Done()
+			defer close(forwarded)
+			for value := range emitted {
+				metrics[i].emitted()
+				if i+1 == len(jobs) {
+					// nobody reads the output of the last stage
+					continue
+				}
+				next := metrics[i+1]
+				next.queue()
+				// the next stage drains its input, this never blocks forever
+				forwarded <- value
+				next.received()
+			}
+		}(i, emitted, forwarded)
+		in = forwarded
+	}
+
+	wg.Wait()
+	if errs.err != nil {
+		return errs.err
+	}
+	return ctx.Err()
+}
+
+func wrapStageErr(i int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("stage %d: %w", i, err)
+}
+
+// report calls OnStats periodically until the returned func is called
+func (p *Pipeline) report(metrics []*stageMetrics) (stop func()) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.OnStats(snapshot(metrics))
+			case <-done:
+				p.OnStats(snapshot(metrics))
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// ParallelMap is a stage calling fn on up to workers values at once. Results are
+// emitted as soon as they are ready, or in input order if ordered is set; a
+// worker slot is only freed once its result was emitted, so at most workers
+// results are ever held back. The first error or panic of fn stops the stage
+func ParallelMap[In, Out any](workers int, fn func(ctx context.Context, value In) (Out, error), ordered bool) Stage[In, Out] {
+	if workers < 1 {
+		workers = 1
+	}
+	type result struct {
+		seq   int
+		value Out
+	}
+	return func(ctx context.Context, in <-chan In, out chan<- Out) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		errs := &firstError{cancel: cancel}
+
+		slots := make(chan struct{}, workers)
+		results := make(chan result)
+		go func() {
+			var wg sync.WaitGroup
+			defer func() {
+				wg.Wait()
+				close(results)
+			}()
+			for seq := 0; ; seq++ {
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				var value In
+				var ok bool
+				select {
+				case value, ok = <-in:
+				case <-ctx.Done():
+				}
+				if !ok {
+					return
+				}
+				wg.Add(1)
+				go func(seq int, value In) {
+					defer wg.Done()
+					defer func() {
+						if r := recover(); r != nil {
+							errs.set(panicError(r))
+						}
+					}()
+					res, err := fn(ctx, value)
+					if err != nil {
+						errs.set(err)
+						return
+					}
+					select {
+					case results <- result{seq, res}:
+					case <-ctx.Done():
+					}
+				}(seq, value)
+			}
+		}()
+
+		// emit releases the slot of a result; after an error results are only drained
+		emit := func(value Out) {
+			errs.set(Emit(ctx, out, value))
+			<-slots
+		}
+		pending := map[int]Out{}
+		next := 0
+		for r := range results {
+			if !ordered {
+				emit(r.value)
+				continue
+			}
+			pending[r.seq] = r.value
+			for value, ok := pending[next]; ok; value, ok = pending[next] {
+				delete(pending, next)
+				emit(value)
+				next++
+			}
+		}
+		if errs.err != nil {
+			return errs.err
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// ContextJob is a pipeline stage that can fail; it should return as soon as ctx is done
+type ContextJob func(ctx context.Context, in, out chan interface{}) error
+
+// Send passes value to the next stage unless ctx is done first
+func Send(ctx context.Context, out chan interface{}, value interface{}) error {
+	select {
+	case out <- value:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ExecutePipelineContext runs jobs on DefaultPipeline, see Pipeline.Run
+func ExecutePipelineContext(ctx context.Context, jobs ...ContextJob) error {
+	return DefaultPipeline.Run(ctx, jobs...)
+}
+
+// runJob turns a panic of job into an error
+func runJob(ctx context.Context, job ContextJob, in, out chan interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	return job(ctx, in, out)
+}
+
+// panicError describes a recovered panic along with the stack of the goroutine
+// that panicked; it must be called from the deferred function that recovered
+func panicError(r interface{}) error {
+	return fmt.Errorf("panic: %v\n\n%s", r, debug.Stack())
+}
+
+// WithContext adapts a job that cannot fail or be cancelled
+func WithContext(j job) ContextJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		j(in, out)
+		return nil
+	}
+}
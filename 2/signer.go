@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"sort"
 	"strconv"
 	"strings"
@@ -9,81 +10,163 @@ import (
 
 const LOOP_SIZE = 6
 
-func doJob(job job, in, out chan interface{}, wg *sync.WaitGroup) {
-	job(in, out)
-	wg.Done()
-	close(out)
-}
-
+// ExecutePipeline runs jobs that cannot fail; a panic in any of them
+// stops the pipeline and is raised again here
 func ExecutePipeline(jobs ...job) {
-	var wg sync.WaitGroup
-	var in = make(chan interface{})
-	var out = make(chan interface{})
-	for _, job := range jobs {
-		in = out
-		out = make(chan interface{})
-		wg.Add(1)
-		go doJob(job, in, out, &wg)
+	ctxJobs := make([]ContextJob, len(jobs))
+	for i, j := range jobs {
+		ctxJobs[i] = WithContext(j)
+	}
+	if err := ExecutePipelineContext(context.Background(), ctxJobs...); err != nil {
+		panic(err)
 	}
-	wg.Wait()
 }
 
+// SignerStage is SingleHash -> MultiHash -> CombineResults checked at compile time
+var SignerStage = defaultSigner.Stage()
+
 func SingleHash(in, out chan interface{}) {
-	var wg sync.WaitGroup
-	for value := range in {
-		m5 := DataSignerMd5(strconv.Itoa(value.(int)))
-		wg.Add(1)
-		go MakeSingleHash(in, out, value, &wg, m5)
+	ToJob(SingleHashStage)(in, out)
+}
+
+func MultiHash(in, out chan interface{}) {
+	ToJob(MultiHashStage)(in, out)
+}
+
+func CombineResults(in, out chan interface{}) {
+	ToJob(CombineResultsStage)(in, out)
+}
+
+// HASH_WORKERS is the number of values SingleHash and MultiHash sign at once
+const HASH_WORKERS = 8
+
+// Md5Guard lets a single DataSignerMd5 call run at a time, it overheats otherwise;
+// it is shared by all pipelines because the overheating is
+var Md5Guard = NewGuard(1, 0)
+
+// SignerOptions configures the signer stages of one pipeline
+type SignerOptions struct {
+	// Workers is the number of values signed at once, HASH_WORKERS if 0
+	Workers int
+	// CacheSize enables LRU caches of that many DataSignerMd5 and DataSignerCrc32
+	// results, shared by the stages of the Signer; 0 calls the signers every time
+	CacheSize int
+}
+
+// Signer builds the SingleHash and MultiHash stages of one pipeline
+type Signer struct {
+	workers int
+	md5     func(ctx context.Context, data string) (string, error)
+	crc32   func(ctx context.Context, data string) (string, error)
+	// Md5Cache and Crc32Cache are nil unless caching is enabled
+	Md5Cache   *Cache[string, string]
+	Crc32Cache *Cache[string, string]
+}
+
+func NewSigner(opts SignerOptions) *Signer {
+	s := &Signer{
+		workers: opts.Workers,
+		// the signers are looked up on every call, they may be replaced
+		md5: Guarded(Md5Guard, func(data string) string {
+			return DataSignerMd5(data)
+		}),
+		crc32: func(ctx context.Context, data string) (string, error) {
+			return DataSignerCrc32(data), nil
+		},
 	}
-	wg.Wait()
+	if s.workers <= 0 {
+		s.workers = HASH_WORKERS
+	}
+	if opts.CacheSize > 0 {
+		s.Md5Cache = NewCache[string, string](opts.CacheSize)
+		s.Crc32Cache = NewCache[string, string](opts.CacheSize)
+		s.md5 = Memoize(s.Md5Cache, s.md5)
+		s.crc32 = Memoize(s.Crc32Cache, s.crc32)
+	}
+	return s
+}
+
+// defaultSigner backs the package level stages and never caches
+var defaultSigner = NewSigner(SignerOptions{})
+
+var (
+	SingleHashStage = defaultSigner.SingleHashStage()
+	MultiHashStage  = defaultSigner.MultiHashStage()
+)
+
+// Stage is SingleHash -> MultiHash -> CombineResults with the options of s
+func (s *Signer) Stage() Stage[int, string] {
+	return Then(Then(s.SingleHashStage(), s.MultiHashStage()), CombineResultsStage)
 }
 
-func MakeSingleHash(in, out chan interface{}, value interface{}, wg *sync.WaitGroup, m5 string) {
-	strValue := strconv.Itoa(value.(int))
-	chan1 := make(chan string)
-	chan2 := make(chan string)
-	go func() {
-		chan1 <- DataSignerCrc32(strValue)
-	}()
-	go func() {
-		chan2 <- DataSignerCrc32(m5)
-	}()
-	out <- (<-chan1) + "~" + (<-chan2)
-	wg.Done()
+// SingleHashStage signs every number as crc32(data)~crc32(md5(data))
+func (s *Signer) SingleHashStage() Stage[int, string] {
+	return ParallelMap(s.workers, s.singleHash, true)
 }
 
-func MultiHash(in, out chan interface{}) {
+func (s *Signer) singleHash(ctx context.Context, value int) (string, error) {
+	data := strconv.Itoa(value)
+	m5, err := s.md5(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	hashes, err := s.crc32All(ctx, []string{data, m5})
+	if err != nil {
+		return "", err
+	}
+	return hashes[0] + "~" + hashes[1], nil
+}
+
+// MultiHashStage concatenates crc32(th+data) for th from 0 to 5
+func (s *Signer) MultiHashStage() Stage[string, string] {
+	return ParallelMap(s.workers, s.multiHash, true)
+}
+
+func (s *Signer) multiHash(ctx context.Context, data string) (string, error) {
+	inputs := make([]string, LOOP_SIZE)
+	for i := range inputs {
+		inputs[i] = strconv.Itoa(i) + data
+	}
+	hashes, err := s.crc32All(ctx, inputs)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(hashes, ""), nil
+}
+
+// crc32All signs all inputs concurrently, a panicking signer is returned as an error
+func (s *Signer) crc32All(ctx context.Context, inputs []string) ([]string, error) {
 	var wg sync.WaitGroup
-	for value := range in {
+	hashes := make([]string, len(inputs))
+	errs := make([]error, len(inputs))
+	for i := range inputs {
 		wg.Add(1)
-		go MakeMultiHash(in, out, value, &wg)
+		go func(i int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = panicError(r)
+				}
+			}()
+			hashes[i], errs[i] = s.crc32(ctx, inputs[i])
+		}(i)
 	}
 	wg.Wait()
-}
-
-func MakeMultiHash(in, out chan interface{}, value interface{}, wg *sync.WaitGroup) {
-	var wgIn sync.WaitGroup
-	hashes := make([]string, LOOP_SIZE)
-	for i := 0; i <= 5; i++ {
-		strIndex := strconv.Itoa(i)
-		index := i
-		wgIn.Add(1)
-		go func() {
-			piece := DataSignerCrc32(strIndex + value.(string))
-			hashes[index] = piece
-			wgIn.Done()
-		}()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
 	}
-	wgIn.Wait()
-	out <- strings.Join(hashes, "")
-	wg.Done()
+	return hashes, nil
 }
 
-func CombineResults(in, out chan interface{}) {
+// CombineResultsStage joins all results with "_" once its input is closed;
+// they are sorted because the signature of a set must not depend on the input order
+func CombineResultsStage(ctx context.Context, in <-chan string, out chan<- string) error {
 	var result []string
 	for value := range in {
-		result = append(result, value.(string))
+		result = append(result, value)
 	}
 	sort.Strings(result)
-	out <- strings.Join(result, "_")
+	return Emit(ctx, out, strings.Join(result, "_"))
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Stage is a typed pipeline step reading In values until in is closed and
+// emitting Out values; like ContextJob it should return once ctx is done
+type Stage[In, Out any] func(ctx context.Context, in <-chan In, out chan<- Out) error
+
+// Emit passes value to the next stage unless ctx is done first
+func Emit[T any](ctx context.Context, out chan<- T, value T) error {
+	select {
+	case out <- value:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// firstError keeps the error that stopped a group of stages and cancels the others
+type firstError struct {
+	once   sync.Once
+	err    error
+	cancel context.CancelFunc
+}
+
+func (f *firstError) set(err error) {
+	if err == nil {
+		return
+	}
+	f.once.Do(func() {
+		f.err = err
+		f.cancel()
+	})
+}
+
+// Then runs first and second concurrently, connected by a typed channel.
+// The error of the stage that failed first is returned
+func Then[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(ctx context.Context, in <-chan A, out chan<- C) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		errs := &firstError{cancel: cancel}
+
+		mid := make(chan B)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer close(mid)
+			defer func() {
+				if r := recover(); r != nil {
+					errs.set(panicError(r))
+				}
+			}()
+			errs.set(first(ctx, in, mid))
+		}()
+		errs.set(second(ctx, mid, out))
+		// second may have stopped early, first must not stay blocked on mid
+		for range mid {
+		}
+		<-done
+		return errs.err
+	}
+}
+
+// ToContextJob adapts a typed stage to the untyped ContextJob; an input value
+// that is not an In fails the stage instead of panicking, and so does a panic of s
+func ToContextJob[In, Out any](s Stage[In, Out]) ContextJob {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		errs := &firstError{cancel: cancel}
+
+		typedIn := make(chan In)
+		typedOut := make(chan Out)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			defer close(typedIn)
+			for {
+				var value interface{}
+				var ok bool
+				select {
+				case value, ok = <-in:
+				case <-ctx.Done():
+					return
+				}
+				if !ok {
+					return
+				}
+				typed, ok := value.(In)
+				if !ok {
+					errs.set(fmt.Errorf("unexpected input %T: %v", value, value))
+					return
+				}
+				if Emit(ctx, typedIn, typed) != nil {
+					return
+				}
+			}
+		}()
+		forwarded := make(chan struct{})
+		go func() {
+			defer wg.Done()
+			defer close(forwarded)
+			for value := range typedOut {
+				errs.set(Send(ctx, out, value))
+			}
+		}()
+
+		err := runStage(ctx, s, typedIn, typedOut)
+		close(typedOut)
+		<-forwarded
+		errs.set(err)
+		cancel()
+		wg.Wait()
+		return errs.err
+	}
+}
+
+// runStage turns a panic of s into an error, like runJob
+func runStage[In, Out any](ctx context.Context, s Stage[In, Out], in <-chan In, out chan<- Out) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+	return s(ctx, in, out)
+}
+
+// ToJob adapts a typed stage to the job of ExecutePipeline, failures panic
+// as they would in an untyped job
+func ToJob[In, Out any](s Stage[In, Out]) job {
+	ctxJob := ToContextJob(s)
+	return func(in, out chan interface{}) {
+		if err := ctxJob(context.Background(), in, out); err != nil {
+			panic(err)
+		}
+	}
+}